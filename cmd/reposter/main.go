@@ -1,7 +1,10 @@
 package main
 
-import "tg-reposter/internal/app"
+import (
+	"os"
+	"tg-reposter/internal/app"
+)
 
 func main() {
-	app.Start()
+	os.Exit(app.Run(os.Args[1:]))
 }
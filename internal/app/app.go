@@ -1,31 +1,238 @@
 package app
 
 import (
+	"context"
+	"flag"
 	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"syscall"
+	"tg-reposter/pkg/kvstore"
 	"tg-reposter/pkg/tgbot"
 	"tg-reposter/pkg/tgclient"
+	"time"
 )
 
 var logger = logrus.WithField("logger", "app")
 
-func Start() {
+const (
+	ExitOk          = 0
+	ExitShutdownErr = 1
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+const defaultStoragePath = "reposter.db"
+
+// Run runs the reposter, or the subcommand given in args, and returns the process exit code.
+func Run(args []string) int {
+	if len(args) == 0 {
+		return Start()
+	}
+	switch args[0] {
+	case "backfill":
+		return Backfill(args[1:])
+	case "replay":
+		return Replay()
+	}
+	logger.Fatalf("unknown command: %s. usage: reposter [backfill [-max-age 24h] [-limit 1000] | replay]", args[0])
+	return ExitShutdownErr
+}
+
+// Start runs the reposter until SIGINT or SIGTERM and returns the process exit code.
+func Start() int {
+	conf, err := LoadConfigFile("config.yaml")
+	if err != nil {
+		logger.Fatalf("config load failed %+v", err)
+	}
+
+	store := prepareStore(conf)
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel)
+
+	client := prepareClient(ctx, conf)
+	bot := prepareBot(conf)
+
+	pipeline := preparePipeline(conf, client, bot, store)
+	if conf.Backfill.OnStart {
+		pipeline.SetBackfill(conf.Backfill.options())
+	}
+
+	updatesDone := startUpdates(ctx, conf, pipeline, bot)
+	err = pipeline.Start(ctx)
+	cancel()
+	<-updatesDone
+
+	code := ExitOk
+	if err != nil {
+		logger.Errorf("%+v", err)
+		code = ExitShutdownErr
+	}
+	code = closeClient(conf, client, code)
+	logger.Infof("stopped, exit code: %d", code)
+	return code
+}
+
+// Backfill reposts messages missed while the reposter was down and returns the process exit code.
+// Flags override the backfill config.
+func Backfill(args []string) int {
+	conf, err := LoadConfigFile("config.yaml")
+	if err != nil {
+		logger.Fatalf("config load failed %+v", err)
+	}
+	opts := conf.Backfill.options()
+
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	flags.DurationVar(&opts.MaxAge, "max-age", opts.MaxAge, "max age of reposted messages, 24h by default")
+	flags.IntVar(&opts.Limit, "limit", opts.Limit, "max messages per source chat, 1000 by default")
+	_ = flags.Parse(args)
+
+	store := prepareStore(conf)
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel)
+
+	client := prepareClient(ctx, conf)
+	bot := prepareBot(conf)
+	pipeline := preparePipeline(conf, client, bot, store)
+
+	code := ExitOk
+	err = pipeline.Backfill(ctx, opts)
+	if err != nil {
+		logger.Errorf("backfill failed. %+v", err)
+		code = ExitShutdownErr
+	}
+	code = closeClient(conf, client, code)
+	logger.Infof("backfill finished, exit code: %d", code)
+	return code
+}
+
+// Replay delivers messages from the dead-letter file again and returns the process exit code.
+func Replay() int {
 	conf, err := LoadConfigFile("config.yaml")
 	if err != nil {
 		logger.Fatalf("config load failed %+v", err)
 	}
 
-	client := prepareClient(conf)
+	store := prepareStore(conf)
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel)
+
+	client := prepareClient(ctx, conf)
 	bot := prepareBot(conf)
+	pipeline := preparePipeline(conf, client, bot, store)
 
-	pipeline := NewPipeline(conf.FilterRegex, client, bot)
-	err = pipeline.Start()
+	code := ExitOk
+	err = pipeline.Replay(ctx)
+	if err != nil {
+		logger.Errorf("replay failed. %+v", err)
+		code = ExitShutdownErr
+	}
+	code = closeClient(conf, client, code)
+	logger.Infof("replay finished, exit code: %d", code)
+	return code
+}
 
+func preparePipeline(conf *Config, client *tgclient.Client, bot *tgbot.Bot, store *kvstore.Store) *Pipeline {
+	pipeline, err := NewPipeline(conf.GetRules(), client, bot)
+	if err != nil {
+		client.Destroy()
+		logger.Fatalf("pipeline build failed. %+v", err)
+	}
+	pipeline.SetDrainTimeout(shutdownTimeout(conf))
+	pipeline.SetQueue(QueueOptions{MaxAttempts: conf.Queue.MaxAttempts, DeadLetterPath: conf.Queue.DeadLetterPath})
+	err = pipeline.SetTopics(conf.Topics)
+	if err != nil {
+		client.Destroy()
+		logger.Fatalf("pipeline build failed. %+v", err)
+	}
+	err = pipeline.SetStore(store)
 	if err != nil {
-		logger.Fatalf("%+v", err)
+		client.Destroy()
+		logger.Fatalf("pipeline store load failed. %+v", err)
 	}
+	if !conf.Dedup.Disabled {
+		pipeline.SetDedup(store, time.Duration(conf.Dedup.Ttl)*time.Second)
+	}
+	return pipeline
 }
 
-func prepareClient(conf *Config) *tgclient.Client {
+func shutdownTimeout(conf *Config) time.Duration {
+	if conf.ShutdownTimeout > 0 {
+		return time.Duration(conf.ShutdownTimeout) * time.Second
+	}
+	return defaultShutdownTimeout
+}
+
+// closeClient closes TDLib and returns the exit code updated with the close result.
+func closeClient(conf *Config, client *tgclient.Client, code int) int {
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), shutdownTimeout(conf))
+	defer closeCancel()
+
+	err := client.Close(closeCtx)
+	if err != nil {
+		logger.Errorf("client close failed. %+v", err)
+		return ExitShutdownErr
+	}
+	return code
+}
+
+// startUpdates receives bot commands in the background, the returned channel is closed when it stops.
+func startUpdates(ctx context.Context, conf *Config, pipeline *Pipeline, bot *tgbot.Bot) <-chan struct{} {
+	done := make(chan struct{})
+	if conf.Bot.Updates == "" {
+		close(done)
+		return done
+	}
+
+	go func() {
+		defer close(done)
+		me, err := bot.GetMeContext(ctx)
+		if err != nil {
+			logger.Errorf("bot commands disabled, getMe failed. %+v", err)
+			return
+		}
+		err = newCommands(pipeline, bot, conf.Bot.Admins).serve(ctx, bot, me, conf.Bot)
+		if err != nil {
+			logger.Errorf("bot commands stopped. %+v", err)
+		}
+	}()
+	return done
+}
+
+func handleSignals(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	logger.Infof("got %s, shutting down", sig)
+	cancel()
+
+	sig = <-signals
+	logger.Fatalf("got %s again, exiting immediately", sig)
+}
+
+func prepareStore(conf *Config) *kvstore.Store {
+	path := conf.Storage.Path
+	if path == "" {
+		path = defaultStoragePath
+	}
+	store, err := kvstore.Open(path)
+	if err != nil {
+		logger.Fatalf("storage open failed. %+v", err)
+	}
+	return store
+}
+
+func prepareClient(ctx context.Context, conf *Config) *tgclient.Client {
 	client := tgclient.NewBuilder().
 		DeviceModel(conf.Client.DeviceModel).
 		SystemVersion(conf.Client.SystemVersion).
@@ -47,8 +254,9 @@ func prepareClient(conf *Config) *tgclient.Client {
 
 	client.SetLogVerbosity(1)
 
-	err := client.Authorize()
+	err := client.AuthorizeContext(ctx)
 	if err != nil {
+		client.Destroy()
 		logger.Fatalf("auth failed. %+v", err)
 	}
 
@@ -58,10 +266,19 @@ func prepareClient(conf *Config) *tgclient.Client {
 func prepareBot(conf *Config) *tgbot.Bot {
 	proxy := conf.Client.Proxy
 
-	bot, err := tgbot.NewBuilder().
+	builder := tgbot.NewBuilder()
+	if conf.Bot.ApiUrl != "" {
+		builder.ApiUrl(conf.Bot.ApiUrl)
+	}
+	bot, err := builder.
 		Token(conf.Bot.Token).
 		TimeoutSec(conf.Bot.Timeout).
 		Socks5Proxy(proxy.Host, proxy.Port, proxy.Login, proxy.Password).
+		// the delivery queue retries failed requests
+		MaxRetries(0).
+		OnChatMigrate(func(from, to int64) {
+			logger.Warnf("chat %d migrated to supergroup %d, update the config", from, to)
+		}).
 		Build()
 
 	if err != nil {
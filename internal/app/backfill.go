@@ -0,0 +1,224 @@
+package app
+
+import (
+	"context"
+	"strconv"
+	"tg-reposter/pkg/kvstore"
+	"tg-reposter/pkg/tgbot"
+	"tg-reposter/pkg/tgclient"
+	"time"
+)
+
+const lastSeenBucket = "last_seen"
+
+const (
+	defaultBackfillMaxAge = 24 * time.Hour
+	defaultBackfillLimit  = 1000
+	historyPageSize       = 100
+	historyRetries        = 5
+	// backfillUpdateBuffer holds new messages received while backfill runs on start
+	backfillUpdateBuffer = 100000
+)
+
+// BackfillOptions bound the history reposted per source chat. History is read back to the last message
+// seen by the pipeline, but not older than MaxAge and not more than Limit messages.
+type BackfillOptions struct {
+	MaxAge time.Duration
+	Limit  int
+}
+
+// lastSeen remembers the newest message id seen per source chat, so backfill starts where the pipeline stopped.
+type lastSeen struct {
+	store *kvstore.Store
+	ids   map[int64]int64
+}
+
+func newLastSeen() *lastSeen {
+	return &lastSeen{ids: map[int64]int64{}}
+}
+
+func (l *lastSeen) load(store *kvstore.Store) error {
+	l.store = store
+	for _, key := range store.Keys(lastSeenBucket) {
+		chatId, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return ValidationErr.Wrap(err, "invalid last seen chat: %s", key)
+		}
+		raw, _ := store.Get(lastSeenBucket, key)
+		msgId, err := strconv.ParseInt(string(raw), 10, 64)
+		if err != nil {
+			return ValidationErr.Wrap(err, "invalid last seen message. chat: %s", key)
+		}
+		l.ids[chatId] = msgId
+	}
+	return nil
+}
+
+func (l *lastSeen) get(chatId int64) int64 {
+	return l.ids[chatId]
+}
+
+func (l *lastSeen) update(chatId, msgId int64) error {
+	if msgId <= l.ids[chatId] {
+		return nil
+	}
+	l.ids[chatId] = msgId
+	if l.store == nil {
+		return nil
+	}
+	return l.store.Put(lastSeenBucket, strconv.FormatInt(chatId, 10), []byte(strconv.FormatInt(msgId, 10)))
+}
+
+// SetBackfill enables backfill of source chats history on start.
+func (p *Pipeline) SetBackfill(opts BackfillOptions) {
+	p.backfillOpts = &opts
+}
+
+// Backfill reposts messages posted to source chats while the pipeline was down and returns.
+func (p *Pipeline) Backfill(ctx context.Context, opts BackfillOptions) error {
+	err := p.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	drainQueue := p.startQueue(ctx)
+	err = p.backfill(ctx, opts)
+	drainQueue()
+	p.logger.Infof("backfill finished, queued: %d", p.queue.size())
+	return err
+}
+
+func (p *Pipeline) backfill(ctx context.Context, opts BackfillOptions) error {
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = defaultBackfillMaxAge
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultBackfillLimit
+	}
+	since := time.Now().Add(-opts.MaxAge)
+	if p.isPaused() {
+		p.logger.Info("pipeline paused, backfill skipped")
+		return nil
+	}
+
+	for _, chatId := range p.sourceChats() {
+		msgs, err := p.loadHistory(ctx, chatId, p.lastSeen.get(chatId), since, opts.Limit)
+		if err != nil {
+			return err
+		}
+		p.logger.Infof("backfill chat %d: %d messages", chatId, len(msgs))
+		p.repostHistory(ctx, msgs)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// sourceChats returns explicit source chats of all rules, rules matching any chat can not be backfilled.
+func (p *Pipeline) sourceChats() []int64 {
+	var ids []int64
+	seen := map[int64]bool{}
+	for _, r := range p.currentRules() {
+		if r.sources == nil {
+			p.logger.Warnf("rule %s has no sources, skipped by backfill", r.name)
+			continue
+		}
+		for id := range r.sources {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// loadHistory pages the chat history backwards from the newest message and returns messages
+// newer than afterId and since in chronological order.
+func (p *Pipeline) loadHistory(ctx context.Context, chatId, afterId int64, since time.Time, limit int) ([]tgclient.Message, error) {
+	var msgs []tgclient.Message
+	var fromId int64
+	for len(msgs) < limit {
+		page, err := p.historyPage(ctx, chatId, fromId)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Messages) == 0 {
+			break
+		}
+		done := false
+		for _, msg := range page.Messages {
+			if msg.Id <= afterId || time.Unix(int64(msg.Date), 0).Before(since) || len(msgs) >= limit {
+				done = true
+				break
+			}
+			msgs = append(msgs, msg)
+			fromId = msg.Id
+		}
+		if done {
+			break
+		}
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+// historyPage loads a history page waiting out flood control.
+func (p *Pipeline) historyPage(ctx context.Context, chatId, fromId int64) (page tgclient.Messages, err error) {
+	for attempt := 1; ; attempt++ {
+		page, err = p.client.GetChatHistoryContext(ctx, chatId, fromId, 0, historyPageSize)
+		if err == nil || attempt >= historyRetries || !tgclient.IsFloodWait(err) {
+			return
+		}
+		delay := tgclient.FloodWait(err)
+		p.logger.Warnf("chat history flood wait %s. chat: %d", delay, chatId)
+		if tgbot.Sleep(ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+// repostHistory reposts messages in order, consecutive messages of an album are reposted together.
+func (p *Pipeline) repostHistory(ctx context.Context, msgs []tgclient.Message) {
+	var album *post
+	var albumId int64
+	flush := func() {
+		if album != nil {
+			p.handlePost(ctx, album)
+			album = nil
+		}
+	}
+	for _, msg := range msgs {
+		if ctx.Err() != nil {
+			return
+		}
+		if msg.MediaAlbumId != albumId {
+			flush()
+			albumId = msg.MediaAlbumId
+		}
+		pt, ok, err := p.filterMessage(ctx, p.botId, msg)
+		if err != nil {
+			p.logger.Errorf("message filter failed. msg: %s. %+v", msg, err)
+		}
+		if ok {
+			if msg.MediaAlbumId == 0 {
+				p.handlePost(ctx, &post{parts: []part{pt}})
+			} else if album == nil {
+				album = &post{parts: []part{pt}}
+			} else {
+				album.parts = append(album.parts, pt)
+			}
+		}
+		p.markSeen(msg)
+	}
+	flush()
+}
+
+func (p *Pipeline) markSeen(msg tgclient.Message) {
+	err := p.lastSeen.update(msg.ChatId, msg.Id)
+	if err != nil {
+		p.logger.Errorf("last seen store failed. msg: %s. %+v", msg, err)
+	}
+}
@@ -0,0 +1,464 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"tg-reposter/pkg/tgbot"
+)
+
+const addRuleUsage = "Usage: /addrule <name> [sources=@chat,-100123] [destinations=@channel] " +
+	"[regex=<regex>] [filter=\"<filter>\"] [mode=copy|forward|quote] [format=entities|html|markdown|plain] " +
+	"[ondelete=delete|mark|ignore] [template=\"<template>\"] [highlight=bold|underline]"
+
+// commands is the bot chat interface of the pipeline. Rule management is allowed to admins only.
+type commands struct {
+	pipeline *Pipeline
+	router   *tgbot.Router
+	admins   map[int64]bool
+}
+
+func newCommands(pipeline *Pipeline, bot *tgbot.Bot, admins []int64) *commands {
+	c := &commands{
+		pipeline: pipeline,
+		router:   tgbot.NewRouter(bot),
+		admins:   map[int64]bool{},
+	}
+	for _, id := range admins {
+		c.admins[id] = true
+	}
+	c.router.
+		Handle("start", "Start the bot", c.start).
+		Handle("help", "List commands", c.help).
+		Handle("topics", "List topics to subscribe to", c.topics).
+		Handle("subscribe", "Subscribe to a topic", c.private(c.subscribe)).
+		Handle("unsubscribe", "Unsubscribe from a topic or all topics", c.private(c.unsubscribe)).
+		Handle("rules", "List repost rules", c.admin(c.rules)).
+		Handle("addrule", "Add a repost rule", c.admin(c.addRule)).
+		Handle("rmrule", "Remove a rule added with /addrule", c.admin(c.removeRule)).
+		Handle("pause", "Pause reposting", c.admin(c.pause)).
+		Handle("resume", "Resume reposting", c.admin(c.resume)).
+		Handle("test", "Show rules matching a text", c.admin(c.test)).
+		Handle("mutes", "List muted sources and senders", c.mutes).
+		Handle("unmute", "Unmute a source or sender", c.unmute).
+		OnUnknown(func(ctx context.Context, cmd tgbot.Command) error {
+			return cmd.Reply(ctx, "Unknown command, see /help")
+		}).
+		OnError(func(ctx context.Context, cmd tgbot.Command, err error) {
+			logger.Errorf("bot command failed. command: %s, chat: %d. %+v", cmd.Name, cmd.Message.Chat.Id, err)
+		}).
+		HandleCallback(muteSourceAction, c.muteSource).
+		HandleCallback(muteSenderAction, c.muteSender).
+		OnCallbackError(func(ctx context.Context, cb tgbot.Callback, err error) {
+			logger.Errorf("bot callback failed. data: %s, user: %d. %+v", cb.Query.Data, cb.Query.From.Id, err)
+		})
+	return c
+}
+
+// admin restricts a command to the admin allowlist.
+func (c *commands) admin(handler tgbot.CommandHandler) tgbot.CommandHandler {
+	return func(ctx context.Context, cmd tgbot.Command) error {
+		if cmd.Message.From == nil || !c.admins[cmd.Message.From.Id] {
+			return cmd.Reply(ctx, "Not allowed")
+		}
+		return handler(ctx, cmd)
+	}
+}
+
+// canMute reports whether the user may change the delivery of the chat the keyboard message is in:
+// admins anywhere, other users in their private chats with the bot.
+func (c *commands) canMute(cb tgbot.Callback) (int64, bool) {
+	msg := cb.Query.Message
+	if msg == nil {
+		return 0, false
+	}
+	return msg.Chat.Id, c.canChangeDelivery(msg.Chat.Id, cb.Query.From.Id)
+}
+
+func (c *commands) canChangeDelivery(chatId, user int64) bool {
+	return c.admins[user] || chatId == user
+}
+
+func (c *commands) muteSource(ctx context.Context, cb tgbot.Callback) error {
+	dest, ok := c.canMute(cb)
+	if !ok {
+		return cb.Answer(ctx, "Not allowed")
+	}
+	chatId, err := strconv.ParseInt(cb.Payload, 10, 64)
+	if err != nil {
+		return cb.Answer(ctx, "Invalid button")
+	}
+	err = c.pipeline.mutes.muteSource(dest, chatId, sourceMuteDuration)
+	if err != nil {
+		return err
+	}
+	logger.Infof("source %d muted for %d by %d", chatId, dest, cb.Query.From.Id)
+	return cb.Answer(ctx, "Source muted for 1 hour, see /mutes")
+}
+
+func (c *commands) muteSender(ctx context.Context, cb tgbot.Callback) error {
+	dest, ok := c.canMute(cb)
+	if !ok {
+		return cb.Answer(ctx, "Not allowed")
+	}
+	userId, err := strconv.ParseInt(cb.Payload, 10, 64)
+	if err != nil {
+		return cb.Answer(ctx, "Invalid button")
+	}
+	err = c.pipeline.mutes.muteSender(dest, userId)
+	if err != nil {
+		return err
+	}
+	logger.Infof("sender %d muted for %d by %d", userId, dest, cb.Query.From.Id)
+	return cb.Answer(ctx, "Sender muted until /unmute, see /mutes")
+}
+
+func (c *commands) mutes(ctx context.Context, cmd tgbot.Command) error {
+	chatId := cmd.Message.Chat.Id
+	if cmd.Message.From == nil || !c.canChangeDelivery(chatId, cmd.Message.From.Id) {
+		return cmd.Reply(ctx, "Not allowed")
+	}
+	list := c.pipeline.mutes.list(chatId)
+	if len(list) == 0 {
+		return cmd.Reply(ctx, "No mutes")
+	}
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		until := "until unmuted"
+		if !list[name].IsZero() {
+			until = "until " + list[name].UTC().Format("2006-01-02 15:04 MST")
+		}
+		fmt.Fprintf(&b, "%s %s\n", name, until)
+	}
+	b.WriteString("\nUnmute with /unmute <name> or /unmute all")
+	return cmd.Reply(ctx, b.String())
+}
+
+func (c *commands) unmute(ctx context.Context, cmd tgbot.Command) error {
+	chatId := cmd.Message.Chat.Id
+	if cmd.Message.From == nil || !c.canChangeDelivery(chatId, cmd.Message.From.Id) {
+		return cmd.Reply(ctx, "Not allowed")
+	}
+	if len(cmd.Args) != 1 {
+		return cmd.Reply(ctx, "Usage: /unmute <name>|all, see /mutes")
+	}
+	names := cmd.Args
+	if cmd.Args[0] == "all" {
+		names = nil
+		for name := range c.pipeline.mutes.list(chatId) {
+			names = append(names, name)
+		}
+	}
+	count := 0
+	for _, name := range names {
+		ok, err := c.pipeline.mutes.unmute(chatId, name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			count++
+		}
+	}
+	if count == 0 {
+		return cmd.Reply(ctx, "Not muted")
+	}
+	logger.Infof("%d mutes removed for %d by %d", count, chatId, cmd.Message.From.Id)
+	return cmd.Reply(ctx, fmt.Sprintf("Unmuted: %d", count))
+}
+
+func (c *commands) start(ctx context.Context, cmd tgbot.Command) error {
+	return cmd.Reply(ctx, "I repost messages from Telegram chats. See /topics to subscribe and /help for commands.")
+}
+
+// private restricts a command to private chats with the bot.
+func (c *commands) private(handler tgbot.CommandHandler) tgbot.CommandHandler {
+	return func(ctx context.Context, cmd tgbot.Command) error {
+		if cmd.Message.Chat.Type != tgbot.ChatPrivate {
+			return cmd.Reply(ctx, "Send this command in a private chat with the bot")
+		}
+		return handler(ctx, cmd)
+	}
+}
+
+func (c *commands) topics(ctx context.Context, cmd tgbot.Command) error {
+	subscribed := map[string]bool{}
+	for _, topic := range c.pipeline.subs.topicsOf(cmd.Message.Chat.Id) {
+		subscribed[topic] = true
+	}
+	var b strings.Builder
+	for _, r := range c.pipeline.currentRules() {
+		if !r.topic {
+			continue
+		}
+		mark := ""
+		if subscribed[r.name] {
+			mark = " (subscribed)"
+		}
+		fmt.Fprintf(&b, "%s%s - %s\n", r.name, mark, r.description)
+	}
+	if b.Len() == 0 {
+		return cmd.Reply(ctx, "No topics")
+	}
+	b.WriteString("\nSubscribe with /subscribe <topic>")
+	return cmd.Reply(ctx, b.String())
+}
+
+func (c *commands) subscribe(ctx context.Context, cmd tgbot.Command) error {
+	if len(cmd.Args) != 1 {
+		return cmd.Reply(ctx, "Usage: /subscribe <topic>, see /topics")
+	}
+	topic := cmd.Args[0]
+	err := c.pipeline.subscribe(topic, cmd.Message.Chat.Id)
+	if err != nil {
+		return cmd.Reply(ctx, "Not subscribed: "+err.Error())
+	}
+	logger.Infof("chat %d subscribed to %s", cmd.Message.Chat.Id, topic)
+	return cmd.Reply(ctx, "Subscribed to "+topic)
+}
+
+func (c *commands) unsubscribe(ctx context.Context, cmd tgbot.Command) error {
+	chatId := cmd.Message.Chat.Id
+	if len(cmd.Args) == 0 {
+		topics, err := c.pipeline.subs.unsubscribeAll(chatId)
+		if err != nil {
+			return err
+		}
+		if len(topics) == 0 {
+			return cmd.Reply(ctx, "No subscriptions")
+		}
+		logger.Infof("chat %d unsubscribed from %v", chatId, topics)
+		return cmd.Reply(ctx, "Unsubscribed from "+strings.Join(topics, ", "))
+	}
+	topic := cmd.Args[0]
+	ok, err := c.pipeline.subs.unsubscribe(topic, chatId)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return cmd.Reply(ctx, "Not subscribed to "+topic)
+	}
+	logger.Infof("chat %d unsubscribed from %s", chatId, topic)
+	return cmd.Reply(ctx, "Unsubscribed from "+topic)
+}
+
+func (c *commands) help(ctx context.Context, cmd tgbot.Command) error {
+	var b strings.Builder
+	for _, command := range c.router.Commands() {
+		fmt.Fprintf(&b, "/%s - %s\n", command.Command, command.Description)
+	}
+	return cmd.Reply(ctx, b.String())
+}
+
+func (c *commands) rules(ctx context.Context, cmd tgbot.Command) error {
+	var b strings.Builder
+	if c.pipeline.isPaused() {
+		b.WriteString("Reposting is paused\n")
+	}
+	for _, r := range c.pipeline.currentRules() {
+		origin := "config"
+		if r.runtime {
+			origin = "runtime"
+		}
+		if r.topic {
+			origin = fmt.Sprintf("topic, %d subscribers", len(c.pipeline.subs.subscribers(r.name)))
+		}
+		fmt.Fprintf(&b, "%s (%s, %s)\n", r.name, r.mode, origin)
+		if len(r.conf.Sources) > 0 {
+			fmt.Fprintf(&b, "  sources: %s\n", joinChatRefs(r.conf.Sources))
+		}
+		if len(r.conf.Destinations) > 0 {
+			fmt.Fprintf(&b, "  destinations: %s\n", joinChatRefs(r.conf.Destinations))
+		}
+		if r.conf.FilterRegex != "" {
+			fmt.Fprintf(&b, "  regex: %s\n", r.conf.FilterRegex)
+		}
+		if r.conf.Filter != "" {
+			fmt.Fprintf(&b, "  filter: %s\n", r.conf.Filter)
+		}
+	}
+	if b.Len() == 0 {
+		return cmd.Reply(ctx, "No rules")
+	}
+	return cmd.Reply(ctx, b.String())
+}
+
+var allowedUpdates = []string{tgbot.UpdateMessage, tgbot.UpdateCallbackQuery}
+
+func (c *commands) addRule(ctx context.Context, cmd tgbot.Command) error {
+	conf, err := parseRuleArgs(cmd.Args)
+	if err != nil {
+		return cmd.Reply(ctx, err.Error()+"\n"+addRuleUsage)
+	}
+	err = c.pipeline.addRule(ctx, conf, true)
+	if err != nil {
+		return cmd.Reply(ctx, "Rule not added: "+err.Error())
+	}
+	logger.Infof("rule %s added by %d", conf.Name, cmd.Message.From.Id)
+	return cmd.Reply(ctx, "Rule "+conf.Name+" added")
+}
+
+func (c *commands) removeRule(ctx context.Context, cmd tgbot.Command) error {
+	if len(cmd.Args) != 1 {
+		return cmd.Reply(ctx, "Usage: /rmrule <name>")
+	}
+	err := c.pipeline.removeRule(cmd.Args[0])
+	if err != nil {
+		return cmd.Reply(ctx, "Rule not removed: "+err.Error())
+	}
+	logger.Infof("rule %s removed by %d", cmd.Args[0], cmd.Message.From.Id)
+	return cmd.Reply(ctx, "Rule "+cmd.Args[0]+" removed")
+}
+
+func (c *commands) pause(ctx context.Context, cmd tgbot.Command) error {
+	err := c.pipeline.setPaused(true)
+	if err != nil {
+		return err
+	}
+	logger.Infof("reposting paused by %d", cmd.Message.From.Id)
+	return cmd.Reply(ctx, "Reposting paused")
+}
+
+func (c *commands) resume(ctx context.Context, cmd tgbot.Command) error {
+	err := c.pipeline.setPaused(false)
+	if err != nil {
+		return err
+	}
+	logger.Infof("reposting resumed by %d", cmd.Message.From.Id)
+	return cmd.Reply(ctx, "Reposting resumed")
+}
+
+func (c *commands) test(ctx context.Context, cmd tgbot.Command) error {
+	if cmd.RawArgs == "" {
+		return cmd.Reply(ctx, "Usage: /test <text>")
+	}
+	names := c.pipeline.testText(cmd.RawArgs)
+	if len(names) == 0 {
+		return cmd.Reply(ctx, "No rules match")
+	}
+	return cmd.Reply(ctx, "Matching rules: "+strings.Join(names, ", "))
+}
+
+// parseRuleArgs parses "/addrule name key=value..." arguments into a rule config.
+func parseRuleArgs(args []string) (conf RuleConfig, err error) {
+	if len(args) == 0 || strings.Contains(args[0], "=") {
+		err = ValidationErr.New("rule name is required")
+		return
+	}
+	conf.Name = args[0]
+	for _, arg := range args[1:] {
+		eq := strings.IndexByte(arg, '=')
+		if eq < 0 {
+			err = ValidationErr.New("invalid argument: %s", arg)
+			return
+		}
+		key, val := arg[:eq], arg[eq+1:]
+		switch key {
+		case "sources":
+			conf.Sources, err = parseChatRefs(val)
+		case "destinations":
+			conf.Destinations, err = parseChatRefs(val)
+		case "regex":
+			conf.FilterRegex = val
+		case "filter":
+			conf.Filter = val
+		case "mode":
+			conf.Mode = DeliveryMode(val)
+		case "format":
+			conf.Format = TextFormat(val)
+		case "ondelete":
+			conf.OnDelete = DeletionMode(val)
+		case "template":
+			conf.Template = val
+		case "highlight":
+			conf.Highlight = val
+		default:
+			err = ValidationErr.New("unknown argument: %s", key)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func parseChatRefs(raw string) ([]ChatRef, error) {
+	var refs []ChatRef
+	for _, part := range strings.Split(raw, ",") {
+		ref, err := ParseChatRef(part)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// serve receives bot updates with polling or a webhook until ctx is done.
+func (c *commands) serve(ctx context.Context, bot *tgbot.Bot, me tgbot.User, conf BotConfig) error {
+	c.router.Username(me.Username)
+	err := bot.SetMyCommandsContext(ctx, c.router.Commands())
+	if err != nil {
+		logger.Warnf("bot commands setup failed. %+v", err)
+	}
+	if conf.Updates == UpdatesWebhook {
+		return c.serveWebhook(ctx, bot, conf.Webhook)
+	}
+
+	err = bot.DeleteWebhookContext(ctx, false)
+	if err != nil {
+		return err
+	}
+	logger.Info("start receiving bot updates")
+	return bot.Poll(ctx, c.router, tgbot.PollOptions{
+		AllowedUpdates: allowedUpdates,
+		OnError: func(err error) {
+			logger.Warnf("bot updates receive failed. %+v", err)
+		},
+	})
+}
+
+func (c *commands) serveWebhook(ctx context.Context, bot *tgbot.Bot, conf WebhookConfig) error {
+	secret := conf.SecretToken
+	if secret == "" {
+		secret = randomToken()
+	}
+	err := bot.SetWebhookContext(ctx, tgbot.WebhookOptions{
+		Url:            conf.Url,
+		SecretToken:    secret,
+		AllowedUpdates: allowedUpdates,
+	})
+	if err != nil {
+		return err
+	}
+	logger.Infof("start receiving bot updates. webhook: %s, listen: %s", conf.Url, conf.Listen)
+	return tgbot.ServeWebhook(ctx, c.router, tgbot.WebhookServerOptions{
+		Listen:      conf.Listen,
+		Path:        conf.Path,
+		SecretToken: secret,
+		CertFile:    conf.CertFile,
+		KeyFile:     conf.KeyFile,
+	})
+}
+
+func randomToken() string {
+	raw := make([]byte, 32)
+	_, _ = rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+func joinChatRefs(refs []ChatRef) string {
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = ref.String()
+	}
+	return strings.Join(names, ", ")
+}
@@ -5,21 +5,187 @@ import (
 	"gopkg.in/yaml.v2"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var Errors = errorx.NewNamespace("config")
 var ParseErr = Errors.NewType("parse")
 var FileErr = Errors.NewType("file")
+var ValidationErr = Errors.NewType("validation")
 
 type Config struct {
-	Client      ClientConfig `yaml:"client"`
-	Bot         BotConfig    `yaml:"bot"`
-	FilterRegex string       `yaml:"filterRegex"`
+	Client          ClientConfig   `yaml:"client"`
+	Bot             BotConfig      `yaml:"bot"`
+	FilterRegex     string         `yaml:"filterRegex"`
+	Rules           []RuleConfig   `yaml:"rules"`
+	Topics          []TopicConfig  `yaml:"topics"`
+	Storage         StorageConfig  `yaml:"storage"`
+	Dedup           DedupConfig    `yaml:"dedup"`
+	Backfill        BackfillConfig `yaml:"backfill"`
+	Queue           QueueConfig    `yaml:"queue"`
+	ShutdownTimeout int            `yaml:"shutdownTimeout"`
 }
 
+type StorageConfig struct {
+	Path string `yaml:"path"`
+}
+
+type DedupConfig struct {
+	Disabled bool `yaml:"disabled"`
+	Ttl      int  `yaml:"ttl"`
+}
+
+// BackfillConfig bounds reposting of messages missed while the reposter was down, MaxAge is in seconds.
+type BackfillConfig struct {
+	OnStart bool `yaml:"onStart"`
+	MaxAge  int  `yaml:"maxAge"`
+	Limit   int  `yaml:"limit"`
+}
+
+func (c BackfillConfig) options() BackfillOptions {
+	return BackfillOptions{
+		MaxAge: time.Duration(c.MaxAge) * time.Second,
+		Limit:  c.Limit,
+	}
+}
+
+// QueueConfig bounds delivery retries of the outbound queue, failed messages are appended to DeadLetterPath.
+type QueueConfig struct {
+	MaxAttempts    int    `yaml:"maxAttempts"`
+	DeadLetterPath string `yaml:"deadLetterPath"`
+}
+
+// GetRules returns configured rules. A config without rules
+// reposts messages from every chat matching FilterRegex to the owner,
+// unless it defines only topics.
+func (c *Config) GetRules() []RuleConfig {
+	if len(c.Rules) > 0 {
+		return c.Rules
+	}
+	if len(c.Topics) > 0 && c.FilterRegex == "" {
+		return nil
+	}
+	return []RuleConfig{{
+		Name:        "default",
+		FilterRegex: c.FilterRegex,
+	}}
+}
+
+// RuleConfig routes messages of source chats matching the filters to destination chats.
+// Empty sources match any chat, empty destinations mean the account owner.
+// Filter is an expression of the filter package, it is applied along with FilterRegex.
+// Mode is a delivery mode, copy by default. Format defines how the source formatting is sent, entities by default.
+// Buttons attach an inline keyboard to copies and quotes: open the original, mute the source or the sender.
+// OnDelete defines what happens to copies of messages deleted at source, they are deleted by default.
+// Template is a text/template of the reposted text, it makes html the default format.
+// Highlight wraps FilterRegex matches in the message text in bold or underline, templates need html or markdown for it.
+type RuleConfig struct {
+	Name         string       `yaml:"name" json:"name"`
+	Sources      []ChatRef    `yaml:"sources" json:"sources,omitempty"`
+	Destinations []ChatRef    `yaml:"destinations" json:"destinations,omitempty"`
+	FilterRegex  string       `yaml:"filterRegex" json:"filterRegex,omitempty"`
+	Filter       string       `yaml:"filter" json:"filter,omitempty"`
+	Mode         DeliveryMode `yaml:"mode" json:"mode,omitempty"`
+	Format       TextFormat   `yaml:"format" json:"format,omitempty"`
+	Buttons      bool         `yaml:"buttons" json:"buttons,omitempty"`
+	OnDelete     DeletionMode `yaml:"onDelete" json:"onDelete,omitempty"`
+	Template     string       `yaml:"template" json:"template,omitempty"`
+	Highlight    string       `yaml:"highlight" json:"highlight,omitempty"`
+}
+
+// TopicConfig is a filter bot users subscribe to with /subscribe, matching messages
+// are delivered to every subscriber. Destinations are not used.
+type TopicConfig struct {
+	RuleConfig  `yaml:",inline"`
+	Description string `yaml:"description"`
+}
+
+// ChatRef references a chat by id, @username or title.
+type ChatRef struct {
+	Id       int64  `json:"id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+func (r *ChatRef) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw string
+	err := unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+	*r, err = ParseChatRef(raw)
+	return err
+}
+
+func (r ChatRef) String() string {
+	if r.Username != "" {
+		return "@" + r.Username
+	}
+	if r.Title != "" {
+		return strconv.Quote(r.Title)
+	}
+	return strconv.FormatInt(r.Id, 10)
+}
+
+func ParseChatRef(raw string) (ChatRef, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ChatRef{}, ValidationErr.New("empty chat reference")
+	}
+	if strings.HasPrefix(raw, "@") {
+		if len(raw) == 1 {
+			return ChatRef{}, ValidationErr.New("empty chat username")
+		}
+		return ChatRef{Username: raw[1:]}, nil
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err == nil {
+		return ChatRef{Id: id}, nil
+	}
+	return ChatRef{Title: raw}, nil
+}
+
+// BotConfig.Updates enables bot commands: "polling" receives updates with getUpdates,
+// "webhook" runs a webhook server, empty disables them. Admins are user ids allowed to manage rules.
 type BotConfig struct {
-	Token   string `yaml:"token"`
-	Timeout int    `yaml:"timeout"`
+	Token   string        `yaml:"token"`
+	Timeout int           `yaml:"timeout"`
+	ApiUrl  string        `yaml:"apiUrl"`
+	Updates string        `yaml:"updates"`
+	Webhook WebhookConfig `yaml:"webhook"`
+	Admins  []int64       `yaml:"admins"`
+}
+
+const (
+	UpdatesPolling = "polling"
+	UpdatesWebhook = "webhook"
+)
+
+// WebhookConfig.Url is the public webhook url registered with setWebhook, Listen and Path
+// are the local server address and path, they differ behind a reverse proxy.
+// A random secret token is generated on start when SecretToken is empty.
+type WebhookConfig struct {
+	Url         string `yaml:"url"`
+	Listen      string `yaml:"listen"`
+	Path        string `yaml:"path"`
+	SecretToken string `yaml:"secretToken"`
+	CertFile    string `yaml:"certFile"`
+	KeyFile     string `yaml:"keyFile"`
+}
+
+func (c BotConfig) validate() error {
+	switch c.Updates {
+	case "", UpdatesPolling:
+		return nil
+	case UpdatesWebhook:
+		if c.Webhook.Url == "" || c.Webhook.Listen == "" {
+			return ValidationErr.New("webhook url and listen address are required")
+		}
+		return nil
+	}
+	return ValidationErr.New("unknown bot updates mode: %s", c.Updates)
 }
 
 type ClientConfig struct {
@@ -51,7 +217,9 @@ func LoadConfig(r io.Reader) (c *Config, err error) {
 	err = d.Decode(c)
 	if err != nil {
 		err = ParseErr.Wrap(err, "config parse failed")
+		return
 	}
+	err = c.Bot.validate()
 	return
 }
 
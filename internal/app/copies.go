@@ -0,0 +1,117 @@
+package app
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"tg-reposter/pkg/kvstore"
+	"tg-reposter/pkg/tgbot"
+	"time"
+)
+
+const copiesBucket = "copies"
+
+// copiesTtl limits how long edits and deletions of source messages are propagated.
+const copiesTtl = 7 * 24 * time.Hour
+
+// copyRef is a reposted copy of a source message in a destination. TextId is the copy message
+// carrying the text, a media caption if Caption is set. Forwarded copies can not be edited.
+// Source is the text of the copy kept for rules marking deleted messages.
+type copyRef struct {
+	Dest     int64   `json:"dest"`
+	Rule     string  `json:"rule"`
+	Messages []int64 `json:"messages"`
+	TextId   int64   `json:"textId,omitempty"`
+	Caption  bool    `json:"caption,omitempty"`
+	Forward  bool    `json:"forward,omitempty"`
+	// Text is set if the source message carries the text of the copy, other album parts only share the copy
+	Text   bool      `json:"text,omitempty"`
+	Source *copyText `json:"source,omitempty"`
+}
+
+// copyText is the rendered text of a copy.
+type copyText struct {
+	Text      string                `json:"text"`
+	ParseMode string                `json:"parseMode,omitempty"`
+	Entities  []tgbot.MessageEntity `json:"entities,omitempty"`
+	Length    int                   `json:"length"`
+}
+
+func newCopyText(r rendered) *copyText {
+	return &copyText{Text: r.text, ParseMode: r.parseMode, Entities: r.entities, Length: r.length}
+}
+
+func (t copyText) rendered() rendered {
+	return rendered{text: t.Text, parseMode: t.ParseMode, entities: t.Entities, length: t.Length}
+}
+
+// copies maps source messages to their reposted copies. The queue adds copies while
+// edits and deletions update them, mu serializes the changes.
+type copies struct {
+	store *kvstore.Store
+	mu    sync.Mutex
+}
+
+func (c *copies) get(chatId, msgId int64) []copyRef {
+	if c.store == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getLocked(chatId, msgId)
+}
+
+func (c *copies) getLocked(chatId, msgId int64) []copyRef {
+	var refs []copyRef
+	_, err := c.store.GetJSON(copiesBucket, sourceKey(chatId, msgId), &refs)
+	if err != nil {
+		return nil
+	}
+	return refs
+}
+
+// add records a copy, replacing a previous copy in the same destination.
+func (c *copies) add(chatId, msgId int64, ref copyRef) error {
+	if c.store == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	refs := []copyRef{ref}
+	for _, old := range c.getLocked(chatId, msgId) {
+		if old.Dest != ref.Dest {
+			refs = append(refs, old)
+		}
+	}
+	return c.put(chatId, msgId, refs)
+}
+
+func (c *copies) remove(chatId, msgId, dest int64) error {
+	if c.store == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var refs []copyRef
+	for _, old := range c.getLocked(chatId, msgId) {
+		if old.Dest != dest {
+			refs = append(refs, old)
+		}
+	}
+	if len(refs) == 0 {
+		return c.store.Delete(copiesBucket, sourceKey(chatId, msgId))
+	}
+	return c.put(chatId, msgId, refs)
+}
+
+func (c *copies) put(chatId, msgId int64, refs []copyRef) error {
+	raw, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+	return c.store.PutTTL(copiesBucket, sourceKey(chatId, msgId), raw, copiesTtl)
+}
+
+func sourceKey(chatId, msgId int64) string {
+	return strconv.FormatInt(chatId, 10) + ":" + strconv.FormatInt(msgId, 10)
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+	"tg-reposter/pkg/kvstore"
+	"time"
+)
+
+func openTestStore(t *testing.T) *kvstore.Store {
+	t.Helper()
+	store, err := kvstore.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("store open failed: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+// withTimeout fails the test if fn does not return in time, e.g. on a deadlock.
+func withTimeout(t *testing.T, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out")
+	}
+}
+
+func TestCopiesAddRemove(t *testing.T) {
+	c := &copies{store: openTestStore(t)}
+
+	withTimeout(t, func() {
+		if err := c.add(1, 10, copyRef{Dest: 100, Messages: []int64{5}}); err != nil {
+			t.Errorf("add: %v", err)
+		}
+		if err := c.add(1, 10, copyRef{Dest: 200, Messages: []int64{6}}); err != nil {
+			t.Errorf("add: %v", err)
+		}
+		if err := c.add(1, 10, copyRef{Dest: 100, Messages: []int64{7}}); err != nil {
+			t.Errorf("add: %v", err)
+		}
+	})
+	refs := c.get(1, 10)
+	if len(refs) != 2 || refs[0].Dest != 100 || refs[0].Messages[0] != 7 || refs[1].Dest != 200 {
+		t.Fatalf("unexpected copies: %+v", refs)
+	}
+
+	withTimeout(t, func() {
+		if err := c.remove(1, 10, 100); err != nil {
+			t.Errorf("remove: %v", err)
+		}
+	})
+	if refs = c.get(1, 10); len(refs) != 1 || refs[0].Dest != 200 {
+		t.Fatalf("unexpected copies after remove: %+v", refs)
+	}
+
+	withTimeout(t, func() {
+		if err := c.remove(1, 10, 200); err != nil {
+			t.Errorf("remove: %v", err)
+		}
+	})
+	if refs = c.get(1, 10); len(refs) != 0 {
+		t.Fatalf("unexpected copies after remove: %+v", refs)
+	}
+}
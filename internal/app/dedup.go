@@ -0,0 +1,111 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"tg-reposter/pkg/kvstore"
+	"time"
+	"unicode"
+)
+
+const (
+	dedupMessagesBucket = "dedup_messages"
+	dedupContentBucket  = "dedup_content"
+)
+
+const defaultDedupTtl = 24 * time.Hour
+
+// dedup remembers reposted messages per destination, both by source message
+// and by normalized content, so replays and cross-posts are reposted once.
+type dedup struct {
+	store *kvstore.Store
+	ttl   time.Duration
+}
+
+func newDedup(store *kvstore.Store, ttl time.Duration) *dedup {
+	if ttl <= 0 {
+		ttl = defaultDedupTtl
+	}
+	return &dedup{
+		store: store,
+		ttl:   ttl,
+	}
+}
+
+// isReposted reports whether the message or its content was reposted to the destination or is queued for it.
+// A content reservation of owner, the queue item being delivered, does not count.
+func (d *dedup) isReposted(dest, chatId, msgId int64, text, owner string) bool {
+	if _, ok := d.store.Get(dedupMessagesBucket, messageKey(dest, chatId, msgId)); ok {
+		return true
+	}
+	if hash := contentHash(text); hash != "" {
+		if val, ok := d.store.Get(dedupContentBucket, destKey(dest, hash)); ok && (owner == "" || string(val) != owner) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dedup) markReposted(dest, chatId, msgId int64, text string) error {
+	err := d.store.PutTTL(dedupMessagesBucket, messageKey(dest, chatId, msgId), nil, d.ttl)
+	if err != nil {
+		return err
+	}
+	if hash := contentHash(text); hash != "" {
+		return d.store.PutTTL(dedupContentBucket, destKey(dest, hash), nil, d.ttl)
+	}
+	return nil
+}
+
+// reserve marks the content hash as reposted by the queue item owner before it is delivered,
+// so the same content posted again meanwhile is skipped.
+func (d *dedup) reserve(dest int64, hash, owner string) error {
+	if hash == "" {
+		return nil
+	}
+	return d.store.PutTTL(dedupContentBucket, destKey(dest, hash), []byte(owner), d.ttl)
+}
+
+// release drops the reservation of an item which was not delivered, delivered content stays marked.
+func (d *dedup) release(dest int64, hash, owner string) error {
+	if hash == "" {
+		return nil
+	}
+	key := destKey(dest, hash)
+	if val, ok := d.store.Get(dedupContentBucket, key); !ok || string(val) != owner {
+		return nil
+	}
+	return d.store.Delete(dedupContentBucket, key)
+}
+
+func messageKey(dest, chatId, msgId int64) string {
+	return destKey(dest, strconv.FormatInt(chatId, 10)+":"+strconv.FormatInt(msgId, 10))
+}
+
+func destKey(dest int64, key string) string {
+	return strconv.FormatInt(dest, 10) + "/" + key
+}
+
+// contentHash hashes text ignoring case, punctuation and whitespace differences.
+func contentHash(text string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		} else {
+			space = true
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
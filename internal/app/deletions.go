@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"tg-reposter/pkg/tgbot"
+	"tg-reposter/pkg/tgclient"
+)
+
+// DeletionMode defines what happens to reposted copies when the source message is deleted.
+type DeletionMode string
+
+const (
+	// DeleteCopy deletes the copies.
+	DeleteCopy DeletionMode = "delete"
+	// DeleteMark prepends deletedMark to the copy text, forwarded copies are left as is.
+	DeleteMark DeletionMode = "mark"
+	// DeleteIgnore keeps the copies.
+	DeleteIgnore DeletionMode = "ignore"
+)
+
+const deletedMark = "[deleted at source]"
+
+func (m DeletionMode) validate() error {
+	switch m {
+	case DeleteCopy, DeleteMark, DeleteIgnore:
+		return nil
+	}
+	return ValidationErr.New("unknown deletion mode: %s", m)
+}
+
+// deleteUpdate is updateDeleteMessages.
+type deleteUpdate struct {
+	ChatId      int64   `json:"chat_id"`
+	MessageIds  []int64 `json:"message_ids"`
+	IsPermanent bool    `json:"is_permanent"`
+	FromCache   bool    `json:"from_cache"`
+}
+
+// handleDeleteEvent applies deletions of reposted messages to their copies. Messages only removed
+// from the TDLib cache, or not deleted permanently, are still in the source chat and are skipped.
+func (p *Pipeline) handleDeleteEvent(ctx context.Context, ev tgclient.Event) {
+	update := deleteUpdate{}
+	err := ev.Unmarshal(&update)
+	if err != nil {
+		p.logger.Errorf("%+v", err)
+		return
+	}
+	if update.FromCache || !update.IsPermanent {
+		return
+	}
+	for _, msgId := range update.MessageIds {
+		for _, ref := range p.copies.get(update.ChatId, msgId) {
+			p.propagateDelete(ctx, update.ChatId, msgId, ref)
+		}
+	}
+}
+
+func (p *Pipeline) propagateDelete(ctx context.Context, chatId, msgId int64, ref copyRef) {
+	mode := DeleteCopy
+	if r := p.findRule(ref.Rule); r != nil {
+		mode = r.onDelete
+	}
+	switch mode {
+	case DeleteIgnore:
+		return
+	case DeleteMark:
+		if ref.Text && !ref.Forward && ref.TextId != 0 && ref.Source != nil {
+			p.markCopy(ctx, chatId, msgId, ref)
+		}
+	default:
+		for _, id := range ref.Messages {
+			err := p.bot.DeleteMessageContext(ctx, ref.Dest, id)
+			if err != nil && !tgbot.IsNotFound(err) {
+				p.logger.Errorf("copy delete failed. dest: %d, copy: %d, chat: %d, msg: %d. %+v", ref.Dest, id, chatId, msgId, err)
+			}
+		}
+		p.logger.Infof("copy deleted. rule: %s, dest: %d, chat: %d, msg: %d", ref.Rule, ref.Dest, chatId, msgId)
+	}
+	err := p.copies.remove(chatId, msgId, ref.Dest)
+	if err != nil {
+		p.logger.Errorf("copy remove failed. chat: %d, msg: %d. %+v", chatId, msgId, err)
+	}
+}
+
+// markCopy edits the copy text to start with deletedMark, the keyboard is dropped with the dead link.
+// Captions which would exceed the limit are replaced with the mark.
+func (p *Pipeline) markCopy(ctx context.Context, chatId, msgId int64, ref copyRef) {
+	text := ref.Source.rendered().prepend(deletedMark + "\n\n")
+	var err error
+	if ref.Caption {
+		if text.length > captionLimit {
+			text = rendered{text: deletedMark, length: len(deletedMark)}
+		}
+		_, err = p.bot.EditMessageCaptionContext(ctx, ref.Dest, ref.TextId, text.mediaOptions())
+	} else {
+		_, err = p.bot.EditMessageTextContext(ctx, ref.Dest, ref.TextId, text.text, text.sendOptions())
+	}
+	if err != nil && !tgbot.IsNotModified(err) {
+		p.logger.Errorf("copy mark failed. dest: %d, chat: %d, msg: %d. %+v", ref.Dest, chatId, msgId, err)
+		return
+	}
+	p.logger.Infof("copy marked deleted. rule: %s, dest: %d, chat: %d, msg: %d", ref.Rule, ref.Dest, chatId, msgId)
+}
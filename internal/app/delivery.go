@@ -0,0 +1,376 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"tg-reposter/internal/filter"
+	"tg-reposter/internal/format"
+	"tg-reposter/pkg/tgbot"
+	"tg-reposter/pkg/tgclient"
+	"unicode/utf8"
+)
+
+// DeliveryMode defines how a matched message is delivered to destinations.
+type DeliveryMode string
+
+const (
+	// DeliveryCopy re-sends the message text.
+	DeliveryCopy DeliveryMode = "copy"
+	// DeliveryForward forwards the original message, the bot must be a member of the source chat.
+	DeliveryForward DeliveryMode = "forward"
+	// DeliveryQuote re-sends the message text under a header naming the source chat and sender.
+	DeliveryQuote DeliveryMode = "quote"
+)
+
+func (m DeliveryMode) validate() error {
+	switch m {
+	case DeliveryCopy, DeliveryForward, DeliveryQuote:
+		return nil
+	}
+	return ValidationErr.New("unknown delivery mode: %s", m)
+}
+
+// TextFormat defines how the source formatting is passed to Bot API.
+type TextFormat string
+
+const (
+	// FormatEntities sends the source entities as is.
+	FormatEntities TextFormat = "entities"
+	// FormatHTML renders the text into HTML parse mode.
+	FormatHTML TextFormat = "html"
+	// FormatMarkdown renders the text into MarkdownV2 parse mode.
+	FormatMarkdown TextFormat = "markdown"
+	// FormatPlain drops the formatting.
+	FormatPlain TextFormat = "plain"
+)
+
+func (f TextFormat) validate() error {
+	switch f {
+	case FormatEntities, FormatHTML, FormatMarkdown, FormatPlain:
+		return nil
+	}
+	return ValidationErr.New("unknown text format: %s", f)
+}
+
+// rendered is a text ready to be sent in one of the formats. Length is the text length in characters,
+// it counts the markup of templates as well.
+type rendered struct {
+	text      string
+	parseMode string
+	entities  []tgbot.MessageEntity
+	markup    *tgbot.InlineKeyboardMarkup
+	length    int
+}
+
+func (f TextFormat) render(t format.Text) rendered {
+	length := utf8.RuneCountInString(t.Text)
+	switch f {
+	case FormatHTML:
+		return rendered{text: format.HTML(t), parseMode: f.parseMode(), length: length}
+	case FormatMarkdown:
+		return rendered{text: format.MarkdownV2(t), parseMode: f.parseMode(), length: length}
+	case FormatPlain:
+		return rendered{text: t.Text, length: length}
+	}
+	return rendered{text: t.Text, entities: t.Entities, length: length}
+}
+
+// prepend adds a plain text before the rendered text.
+func (r rendered) prepend(text string) rendered {
+	switch r.parseMode {
+	case tgbot.ParseModeHTML:
+		r.text = format.EscapeHTML(text) + r.text
+	case tgbot.ParseModeMarkdownV2:
+		r.text = format.EscapeMarkdownV2(text) + r.text
+	default:
+		r.entities = format.Shift(r.entities, format.Len(text))
+		r.text = text + r.text
+	}
+	r.length += utf8.RuneCountInString(text)
+	return r
+}
+
+func (r rendered) sendOptions() tgbot.SendOptions {
+	return tgbot.SendOptions{ParseMode: r.parseMode, Entities: r.entities, ReplyMarkup: r.markup}
+}
+
+func (r rendered) mediaOptions() tgbot.MediaOptions {
+	return tgbot.MediaOptions{Caption: r.text, ParseMode: r.parseMode, CaptionEntities: r.entities, ReplyMarkup: r.markup}
+}
+
+// route is a destination of a matched message.
+type route struct {
+	dest int64
+	rule *rule
+}
+
+// deliver sends the post to the route destination and returns the sent copy.
+func (p *Pipeline) deliver(ctx context.Context, r route, pst *post) (copyRef, error) {
+	ref := copyRef{Dest: r.dest, Rule: r.rule.name}
+	switch r.rule.mode {
+	case DeliveryForward:
+		ref.Forward = true
+		for _, pt := range pst.parts {
+			msg := pt.in.Message
+			sent, err := p.bot.ForwardMessageContext(ctx, r.dest, msg.ChatId, msg.ServerId())
+			if err != nil {
+				return ref, err
+			}
+			ref.Messages = append(ref.Messages, sent.MessageId)
+		}
+		return ref, nil
+	default:
+		if r.rule.usesMetadata() {
+			p.loadMetadata(ctx, pst.main().in)
+		}
+		text, err := r.rule.render(pst.main())
+		if err != nil {
+			return ref, err
+		}
+		return p.sendPost(ctx, r, pst, text, ref)
+	}
+}
+
+// render renders the text of a part as the rule delivers it.
+func (r *rule) render(pt part) (rendered, error) {
+	if r.template != nil {
+		return r.renderTemplate(pt)
+	}
+	return r.format.render(postText(r, pt)), nil
+}
+
+// renderCaption renders the text of a part cut to the caption limit. Templates can not be cut
+// without breaking the markup, too long template captions fail.
+func (r *rule) renderCaption(pt part) (rendered, error) {
+	if r.template == nil {
+		return r.format.render(format.Truncate(postText(r, pt), captionLimit)), nil
+	}
+	caption, err := r.renderTemplate(pt)
+	if err == nil && caption.length > captionLimit {
+		err = TemplateErr.New("template caption is too long. rule: %s, length: %d", r.name, caption.length)
+	}
+	return caption, err
+}
+
+// postText returns the text of a part as the rule delivers it without a template.
+func postText(r *rule, pt part) format.Text {
+	if r.mode == DeliveryQuote {
+		return quoteText(pt, r.messageText(pt))
+	}
+	return r.messageText(pt)
+}
+
+// messageText returns the message text with rule regex matches highlighted.
+func (r *rule) messageText(pt part) format.Text {
+	if r.highlight == "" {
+		return pt.text()
+	}
+	return format.Highlight(pt.text(), r.re, r.highlight)
+}
+
+func quoteText(pt part, text format.Text) format.Text {
+	var b strings.Builder
+	b.WriteString(quoteHeader(pt.in))
+	if link := messageLink(pt.in.Chat, pt.in.ChatUsername, pt.in.Message.ServerId()); link != "" {
+		b.WriteString("\n")
+		b.WriteString(link)
+	}
+	b.WriteString("\n\n")
+	return format.Concat(format.Plain(b.String()), text)
+}
+
+func quoteHeader(in *filter.Input) string {
+	header := in.Chat.Title
+	if header == "" {
+		header = strconv.FormatInt(in.Message.ChatId, 10)
+	}
+	sender := senderName(in.Sender)
+	if sender != "" && sender != in.Chat.Title {
+		header += " · " + sender
+	}
+	return header
+}
+
+func senderName(u tgclient.User) string {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if u.UserName != "" {
+		if name == "" {
+			return "@" + u.UserName
+		}
+		return fmt.Sprintf("%s (@%s)", name, u.UserName)
+	}
+	return name
+}
+
+// messageLink returns a t.me link to a message of a public or private supergroup, empty for other chats.
+func messageLink(chat tgclient.Chat, username string, serverMsgId int64) string {
+	if chat.Type.Type != tgclient.ChatTypeSupergroupType {
+		return ""
+	}
+	if username != "" {
+		return fmt.Sprintf("https://t.me/%s/%d", username, serverMsgId)
+	}
+	return fmt.Sprintf("https://t.me/c/%d/%d", chat.Type.SupergroupId, serverMsgId)
+}
+
+const (
+	muteSourceAction = "mute_chat"
+	muteSenderAction = "mute_user"
+)
+
+// postKeyboard returns buttons opening the original message and muting its source chat or sender.
+func postKeyboard(pt part) *tgbot.InlineKeyboardMarkup {
+	in := pt.in
+	var rows [][]tgbot.InlineKeyboardButton
+	if link := messageLink(in.Chat, in.ChatUsername, in.Message.ServerId()); link != "" {
+		rows = append(rows, []tgbot.InlineKeyboardButton{tgbot.UrlButton("Open original", link)})
+	}
+	mute := []tgbot.InlineKeyboardButton{
+		tgbot.CallbackButton("Mute this source for 1h",
+			tgbot.CallbackData(muteSourceAction, strconv.FormatInt(in.Message.ChatId, 10))),
+	}
+	if in.Message.SenderUserId != 0 {
+		mute = append(mute, tgbot.CallbackButton("Mute this sender until unmuted",
+			tgbot.CallbackData(muteSenderAction, strconv.FormatInt(int64(in.Message.SenderUserId), 10))))
+	}
+	rows = append(rows, mute)
+	return tgbot.NewInlineKeyboard(rows...)
+}
+
+// captionLimit is the Bot API limit of media captions, longer texts are sent as a separate message.
+const captionLimit = 1024
+
+func (p *Pipeline) sendPost(ctx context.Context, r route, pst *post, text rendered, ref copyRef) (copyRef, error) {
+	if r.rule.onDelete == DeleteMark {
+		ref.Source = newCopyText(text)
+	}
+	caption := text
+	if text.length > captionLimit || pst.isSticker() {
+		caption = rendered{}
+	}
+	if r.rule.conf.Buttons {
+		// the keyboard goes to the last sent message: the text sent after the media or the media itself
+		text.markup = postKeyboard(pst.main())
+		if caption.text != "" || text.text == "" {
+			caption.markup = text.markup
+		}
+	}
+
+	if len(pst.parts) == 1 {
+		sent, err := p.sendPart(ctx, r.dest, pst.parts[0], text, caption)
+		if err != nil {
+			return ref, err
+		}
+		ref.Messages = []int64{sent.MessageId}
+		if pst.isText() || caption.text != "" {
+			ref.TextId, ref.Caption = sent.MessageId, !pst.isText()
+		}
+	} else {
+		sent, err := p.sendAlbum(ctx, r.dest, pst, caption)
+		if err != nil {
+			return ref, err
+		}
+		for _, m := range sent {
+			ref.Messages = append(ref.Messages, m.MessageId)
+		}
+		if caption.text != "" && len(sent) > 0 {
+			ref.TextId, ref.Caption = sent[0].MessageId, true
+		}
+	}
+
+	if caption.text == "" && text.text != "" && !pst.isText() {
+		sent, err := p.bot.SendMessageWithOptionsContext(ctx, r.dest, text.text, text.sendOptions())
+		if err != nil {
+			return ref, err
+		}
+		ref.Messages = append(ref.Messages, sent.MessageId)
+		ref.TextId, ref.Caption = sent.MessageId, false
+	}
+	return ref, nil
+}
+
+func (p *Pipeline) sendPart(ctx context.Context, dest int64, pt part, text, caption rendered) (tgbot.Message, error) {
+	file, ok := pt.file()
+	if !ok {
+		if text.text == "" {
+			return tgbot.Message{}, UnsupportedErr.New("unsupported content: %s", pt.in.ContentType)
+		}
+		return p.bot.SendMessageWithOptionsContext(ctx, dest, text.text, text.sendOptions())
+	}
+
+	input, err := p.downloadFile(ctx, file)
+	if err != nil {
+		return tgbot.Message{}, err
+	}
+
+	opts := caption.mediaOptions()
+	switch c := pt.content.(type) {
+	case *tgclient.MessagePhoto:
+		return p.bot.SendPhotoContext(ctx, dest, input, opts)
+	case *tgclient.MessageVideo:
+		opts.Duration, opts.Width, opts.Height = c.Video.Duration, c.Video.Width, c.Video.Height
+		return p.bot.SendVideoContext(ctx, dest, input, opts)
+	case *tgclient.MessageDocument:
+		return p.bot.SendDocumentContext(ctx, dest, input, opts)
+	case *tgclient.MessageAnimation:
+		opts.Duration, opts.Width, opts.Height = c.Animation.Duration, c.Animation.Width, c.Animation.Height
+		return p.bot.SendAnimationContext(ctx, dest, input, opts)
+	case *tgclient.MessageVoiceNote:
+		opts.Duration = c.VoiceNote.Duration
+		return p.bot.SendVoiceContext(ctx, dest, input, opts)
+	case *tgclient.MessageAudio:
+		opts.Duration = c.Audio.Duration
+		return p.bot.SendAudioContext(ctx, dest, input, opts)
+	case *tgclient.MessageSticker:
+		return p.bot.SendStickerContext(ctx, dest, input)
+	}
+	return tgbot.Message{}, UnsupportedErr.New("unsupported content: %s", pt.in.ContentType)
+}
+
+func (p *Pipeline) sendAlbum(ctx context.Context, dest int64, pst *post, caption rendered) ([]tgbot.Message, error) {
+	media := make([]tgbot.InputMedia, 0, len(pst.parts))
+	for _, pt := range pst.parts {
+		var mediaType string
+		switch pt.content.(type) {
+		case *tgclient.MessagePhoto:
+			mediaType = tgbot.MediaPhoto
+		case *tgclient.MessageVideo:
+			mediaType = tgbot.MediaVideo
+		case *tgclient.MessageDocument:
+			mediaType = tgbot.MediaDocument
+		case *tgclient.MessageAudio:
+			mediaType = tgbot.MediaAudio
+		default:
+			return nil, UnsupportedErr.New("unsupported album content: %s", pt.in.ContentType)
+		}
+		file, _ := pt.file()
+		input, err := p.downloadFile(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		item := tgbot.InputMedia{Type: mediaType, Media: input}
+		if len(media) == 0 {
+			item.Caption = caption.text
+			item.ParseMode = caption.parseMode
+			item.CaptionEntities = caption.entities
+		}
+		media = append(media, item)
+	}
+	return p.bot.SendMediaGroupContext(ctx, dest, media)
+}
+
+func (p *Pipeline) downloadFile(ctx context.Context, file tgclient.File) (tgbot.InputFile, error) {
+	if !file.Local.IsDownloadingCompleted {
+		var err error
+		file, err = p.client.DownloadFileContext(ctx, file.Id)
+		if err != nil {
+			return tgbot.InputFile{}, err
+		}
+	}
+	if !file.Local.IsDownloadingCompleted || file.Local.Path == "" {
+		return tgbot.InputFile{}, DownloadErr.New("file download incomplete. file: %d", file.Id)
+	}
+	return tgbot.InputFile{Path: file.Local.Path}, nil
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"strconv"
+	"sync"
+	"tg-reposter/pkg/kvstore"
+)
+
+const disabledDestinationsBucket = "disabled_destinations"
+
+// destinations tracks destinations the bot lost access to. A disabled destination
+// is skipped until it is removed from the store, the state survives restarts when a store is set.
+type destinations struct {
+	store    *kvstore.Store
+	mu       sync.RWMutex
+	disabled map[int64]bool
+}
+
+func newDestinations() *destinations {
+	return &destinations{disabled: map[int64]bool{}}
+}
+
+func (d *destinations) load(store *kvstore.Store) error {
+	d.store = store
+	for _, key := range store.Keys(disabledDestinationsBucket) {
+		id, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return ValidationErr.Wrap(err, "invalid disabled destination: %s", key)
+		}
+		d.disabled[id] = true
+	}
+	return nil
+}
+
+func (d *destinations) isDisabled(dest int64) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.disabled[dest]
+}
+
+// enable removes a destination from the disabled ones, e.g. after a user subscribed again.
+func (d *destinations) enable(dest int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.disabled[dest] {
+		return nil
+	}
+	delete(d.disabled, dest)
+	if d.store == nil {
+		return nil
+	}
+	return d.store.Delete(disabledDestinationsBucket, strconv.FormatInt(dest, 10))
+}
+
+func (d *destinations) disable(dest int64, reason string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.disabled[dest] = true
+	if d.store == nil {
+		return nil
+	}
+	return d.store.Put(disabledDestinationsBucket, strconv.FormatInt(dest, 10), []byte(reason))
+}
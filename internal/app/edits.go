@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"tg-reposter/pkg/tgbot"
+	"tg-reposter/pkg/tgclient"
+)
+
+// editsSeenSize bounds the memory of handled edits, it only has to outlive a burst of updates of one edit.
+const editsSeenSize = 1000
+
+// editUpdate is the common part of updateMessageContent and updateMessageEdited.
+type editUpdate struct {
+	ChatId    int64 `json:"chat_id"`
+	MessageId int64 `json:"message_id"`
+}
+
+// recordCopy maps every part of the post to the delivered copy. The part carrying the text owns
+// the whole copy, other album parts only their own copy messages.
+func (p *Pipeline) recordCopy(pst *post, ref copyRef) {
+	mainId := pst.main().in.Message.Id
+	for i, pt := range pst.parts {
+		msg := pt.in.Message
+		partRef := ref
+		partRef.Text = msg.Id == mainId
+		if !partRef.Text {
+			partRef.Source = nil
+			if len(ref.Messages) >= len(pst.parts) {
+				partRef.Messages = []int64{ref.Messages[i]}
+			}
+		}
+		err := p.copies.add(msg.ChatId, msg.Id, partRef)
+		if err != nil {
+			p.logger.Errorf("copy store failed. msg: %s. %+v", msg, err)
+		}
+	}
+}
+
+// handleEditEvent propagates an edit of a reposted message to its copies. TDLib sends both
+// updateMessageContent and updateMessageEdited for an edit, the second one is skipped by the edit date.
+func (p *Pipeline) handleEditEvent(ctx context.Context, ev tgclient.Event) {
+	update := editUpdate{}
+	err := ev.Unmarshal(&update)
+	if err != nil {
+		p.logger.Errorf("%+v", err)
+		return
+	}
+	refs := p.copies.get(update.ChatId, update.MessageId)
+	if len(refs) == 0 {
+		return
+	}
+
+	msg, err := p.client.GetMessageContext(ctx, update.ChatId, update.MessageId)
+	if err != nil {
+		p.logger.Errorf("edited message load failed. chat: %d, msg: %d. %+v", update.ChatId, update.MessageId, err)
+		return
+	}
+	key := sourceKey(msg.ChatId, msg.Id)
+	if msg.EditDate != 0 && p.editsSeen[key] >= msg.EditDate {
+		return
+	}
+	if len(p.editsSeen) >= editsSeenSize {
+		p.editsSeen = map[string]int32{}
+	}
+	p.editsSeen[key] = msg.EditDate
+
+	pt, ok, err := p.filterMessage(ctx, p.botId, msg)
+	if err != nil {
+		p.logger.Errorf("message filter failed. msg: %s. %+v", msg, err)
+	}
+	if !ok {
+		return
+	}
+	for _, ref := range refs {
+		p.propagateEdit(ctx, pt, ref)
+	}
+}
+
+// propagateEdit updates the copy text, or retracts the copy if the message no longer matches its rule.
+// Only the album part carrying the text is checked, other parts have no text to match.
+func (p *Pipeline) propagateEdit(ctx context.Context, pt part, ref copyRef) {
+	msg := pt.in.Message
+	if !ref.Text {
+		return
+	}
+	r := p.findRule(ref.Rule)
+	if r == nil || !p.matchRule(ctx, r, pt.in) {
+		p.retractCopy(ctx, msg, ref)
+		return
+	}
+	if ref.Forward || ref.TextId == 0 {
+		return
+	}
+	if r.usesMetadata() {
+		p.loadMetadata(ctx, pt.in)
+	}
+
+	var markup *tgbot.InlineKeyboardMarkup
+	if r.conf.Buttons {
+		markup = postKeyboard(pt)
+	}
+	var text rendered
+	var err error
+	if ref.Caption {
+		text, err = r.renderCaption(pt)
+		if err == nil {
+			text.markup = markup
+			_, err = p.bot.EditMessageCaptionContext(ctx, ref.Dest, ref.TextId, text.mediaOptions())
+		}
+	} else {
+		text, err = r.render(pt)
+		if err == nil && text.text == "" {
+			return
+		}
+		if err == nil {
+			text.markup = markup
+			_, err = p.bot.EditMessageTextContext(ctx, ref.Dest, ref.TextId, text.text, text.sendOptions())
+		}
+	}
+	if err != nil && !tgbot.IsNotModified(err) {
+		p.logger.Errorf("copy edit failed. dest: %d, msg: %s. %+v", ref.Dest, msg, err)
+		return
+	}
+	p.logger.Infof("copy edited. rule: %s, dest: %d, msg: %s", r.name, ref.Dest, msg)
+	if ref.Source != nil {
+		ref.Source = newCopyText(text)
+		err = p.copies.add(msg.ChatId, msg.Id, ref)
+		if err != nil {
+			p.logger.Errorf("copy store failed. msg: %s. %+v", msg, err)
+		}
+	}
+}
+
+// retractCopy deletes all messages of the copy and forgets it.
+func (p *Pipeline) retractCopy(ctx context.Context, msg tgclient.Message, ref copyRef) {
+	for _, id := range ref.Messages {
+		err := p.bot.DeleteMessageContext(ctx, ref.Dest, id)
+		if err != nil && !tgbot.IsNotFound(err) {
+			p.logger.Errorf("copy delete failed. dest: %d, copy: %d, msg: %s. %+v", ref.Dest, id, msg, err)
+		}
+	}
+	err := p.copies.remove(msg.ChatId, msg.Id, ref.Dest)
+	if err != nil {
+		p.logger.Errorf("copy remove failed. msg: %s. %+v", msg, err)
+	}
+	p.logger.Infof("copy retracted. rule: %s, dest: %d, msg: %s", ref.Rule, ref.Dest, msg)
+}
+
+func (p *Pipeline) findRule(name string) *rule {
+	for _, r := range p.currentRules() {
+		if r.name == name {
+			return r
+		}
+	}
+	return nil
+}
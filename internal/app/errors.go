@@ -0,0 +1,10 @@
+package app
+
+import "github.com/joomcode/errorx"
+
+var PipelineErrors = errorx.NewNamespace("pipeline")
+var DrainErr = PipelineErrors.NewType("drain")
+var UnsupportedErr = PipelineErrors.NewType("unsupported")
+var DownloadErr = PipelineErrors.NewType("download")
+var QueueErr = PipelineErrors.NewType("queue")
+var TemplateErr = PipelineErrors.NewType("template")
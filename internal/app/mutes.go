@@ -0,0 +1,131 @@
+package app
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"tg-reposter/pkg/kvstore"
+	"time"
+)
+
+const mutesBucket = "mutes"
+
+const sourceMuteDuration = time.Hour
+
+// mutes are sources and senders muted per destination with keyboard buttons and removed with /unmute.
+// A zero expiration time mutes until unmuted.
+type mutes struct {
+	store *kvstore.Store
+	mu    sync.RWMutex
+	until map[string]time.Time
+}
+
+func newMutes() *mutes {
+	return &mutes{until: map[string]time.Time{}}
+}
+
+func (m *mutes) load(store *kvstore.Store) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = store
+	for _, key := range store.Keys(mutesBucket) {
+		var until time.Time
+		_, err := store.GetJSON(mutesBucket, key, &until)
+		if err != nil {
+			return err
+		}
+		m.until[key] = until
+	}
+	return nil
+}
+
+func (m *mutes) muteSource(dest, chatId int64, d time.Duration) error {
+	return m.mute(muteKey(dest, "chat", chatId), d)
+}
+
+func (m *mutes) muteSender(dest int64, userId int64) error {
+	return m.mute(muteKey(dest, "user", userId), 0)
+}
+
+func (m *mutes) isMuted(dest, chatId int64, userId int64) bool {
+	return m.active(muteKey(dest, "chat", chatId)) || userId != 0 && m.active(muteKey(dest, "user", userId))
+}
+
+func (m *mutes) mute(key string, d time.Duration) error {
+	var until time.Time
+	if d > 0 {
+		until = time.Now().Add(d)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.until[key] = until
+	if m.store == nil {
+		return nil
+	}
+	raw, err := json.Marshal(until)
+	if err != nil {
+		return err
+	}
+	if d > 0 {
+		return m.store.PutTTL(mutesBucket, key, raw, d)
+	}
+	return m.store.Put(mutesBucket, key, raw)
+}
+
+// active reports whether the key is muted, expired mutes are dropped.
+func (m *mutes) active(key string) bool {
+	m.mu.RLock()
+	until, ok := m.until[key]
+	m.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	if until.IsZero() || time.Now().Before(until) {
+		return true
+	}
+	m.mu.Lock()
+	if current, ok := m.until[key]; ok && current.Equal(until) {
+		delete(m.until, key)
+	}
+	m.mu.Unlock()
+	return false
+}
+
+// list returns active mutes of the destination by "chat:<id>" and "user:<id>" names.
+func (m *mutes) list(dest int64) map[string]time.Time {
+	prefix := destKey(dest, "")
+	now := time.Now()
+	list := map[string]time.Time{}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for key, until := range m.until {
+		if strings.HasPrefix(key, prefix) && (until.IsZero() || now.Before(until)) {
+			list[strings.TrimPrefix(key, prefix)] = until
+		}
+	}
+	return list
+}
+
+// unmute removes a mute of the destination by its list name, false means it was not muted.
+func (m *mutes) unmute(dest int64, name string) (bool, error) {
+	key := destKey(dest, name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.until[key]; !ok {
+		return false, nil
+	}
+	delete(m.until, key)
+	if m.store == nil {
+		return true, nil
+	}
+	return true, m.store.Delete(mutesBucket, key)
+}
+
+func muteKey(dest int64, kind string, id int64) string {
+	return destKey(dest, muteName(kind, id))
+}
+
+func muteName(kind string, id int64) string {
+	return kind + ":" + strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMutesUnmute(t *testing.T) {
+	store := openTestStore(t)
+	m := newMutes()
+	if err := m.load(store); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.muteSender(1, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.muteSource(1, -100, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !m.isMuted(1, -200, 42) || !m.isMuted(1, -100, 0) || m.isMuted(2, -100, 42) {
+		t.Fatal("unexpected mutes")
+	}
+	if list := m.list(1); len(list) != 2 || !list["user:42"].IsZero() {
+		t.Fatalf("list: %v", list)
+	}
+
+	ok, err := m.unmute(1, "user:42")
+	if !ok || err != nil {
+		t.Fatalf("unmute: %v, %v", ok, err)
+	}
+	if m.isMuted(1, -200, 42) {
+		t.Fatal("sender still muted")
+	}
+	restored := newMutes()
+	if err = restored.load(store); err != nil {
+		t.Fatal(err)
+	}
+	if list := restored.list(1); len(list) != 1 {
+		t.Fatalf("restored list: %v", list)
+	}
+}
+
+func TestMutesDropExpired(t *testing.T) {
+	m := newMutes()
+	key := muteKey(1, "chat", -100)
+	m.until[key] = time.Now().Add(-time.Second)
+	if m.isMuted(1, -100, 0) {
+		t.Fatal("expired mute active")
+	}
+	if _, ok := m.until[key]; ok {
+		t.Fatal("expired mute kept")
+	}
+}
@@ -1,58 +1,386 @@
 package app
 
 import (
+	"context"
 	"github.com/sirupsen/logrus"
-	"regexp"
+	"sync"
+	"tg-reposter/internal/filter"
+	"tg-reposter/pkg/kvstore"
 	"tg-reposter/pkg/tgbot"
 	"tg-reposter/pkg/tgclient"
+	"time"
 )
 
 type Pipeline struct {
-	logger *logrus.Entry
-	client *tgclient.Client
-	bot    *tgbot.Bot
-	re     *regexp.Regexp
+	logger       *logrus.Entry
+	client       *tgclient.Client
+	bot          *tgbot.Bot
+	store        *kvstore.Store
+	dedup        *dedup
+	destinations *destinations
+	subs         *subscriptions
+	mutes        *mutes
+	copies       *copies
+	queue        *queue
+	lastSeen     *lastSeen
+	backfillOpts *BackfillOptions
+	editsSeen    map[string]int32
+	albums       *albumCollector
+	drainTimeout time.Duration
+
+	// mu guards rules and state changed at runtime by bot commands
+	mu       sync.RWMutex
+	rules    []*rule
+	paused   bool
+	resolver *chatResolver
+	ownerId  int64
+	botId    int64
 }
 
-func NewPipeline(regexMatch string, client *tgclient.Client, bot *tgbot.Bot) *Pipeline {
-	re := regexp.MustCompile(regexMatch)
+func NewPipeline(rules []RuleConfig, client *tgclient.Client, bot *tgbot.Bot) (*Pipeline, error) {
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return nil, err
+	}
 	return &Pipeline{
-		client: client,
-		bot:    bot,
-		re:     re,
-		logger: logrus.WithField("logger", "pipeline"),
+		client:       client,
+		bot:          bot,
+		rules:        compiled,
+		destinations: newDestinations(),
+		subs:         newSubscriptions(),
+		mutes:        newMutes(),
+		copies:       &copies{},
+		queue:        newQueue(),
+		lastSeen:     newLastSeen(),
+		editsSeen:    map[string]int32{},
+		albums:       newAlbumCollector(albumWait),
+		drainTimeout: defaultShutdownTimeout,
+		logger:       logrus.WithField("logger", "pipeline"),
+	}, nil
+}
+
+// SetDrainTimeout limits how long accepted messages are reposted after the pipeline is stopped.
+func (p *Pipeline) SetDrainTimeout(timeout time.Duration) {
+	p.drainTimeout = timeout
+}
+
+// SetDedup enables skipping messages already reposted within ttl.
+func (p *Pipeline) SetDedup(store *kvstore.Store, ttl time.Duration) {
+	p.dedup = newDedup(store, ttl)
+}
+
+// SetStore persists the outbound queue, destinations disabled after the bot lost access to them,
+// subscriptions, mutes, copies of reposted messages and runtime state.
+// Runtime rules and the paused state are loaded on start.
+func (p *Pipeline) SetStore(store *kvstore.Store) error {
+	p.store = store
+	p.copies.store = store
+	err := p.queue.load(store)
+	if err != nil {
+		return err
+	}
+	err = p.destinations.load(store)
+	if err != nil {
+		return err
 	}
+	err = p.mutes.load(store)
+	if err != nil {
+		return err
+	}
+	err = p.lastSeen.load(store)
+	if err != nil {
+		return err
+	}
+	return p.subs.load(store)
 }
 
-func (p *Pipeline) Start() error {
-	bot, err := p.bot.GetMe()
-	me, err := p.client.GetMe()
+// SetTopics adds topics bot users subscribe to, they are matched after rules.
+func (p *Pipeline) SetTopics(topics []TopicConfig) error {
+	compiled, err := compileTopics(topics)
+	if err != nil {
+		return err
+	}
+	for _, t := range compiled {
+		for _, r := range p.rules {
+			if r.name == t.name {
+				return ValidationErr.New("topic name is used by a rule: %s", t.name)
+			}
+		}
+	}
+	p.rules = append(p.rules, compiled...)
+	return nil
+}
 
+// Start reposts new messages until ctx is done. After that it stops accepting
+// updates and drains already accepted messages within the drain timeout,
+// messages waiting for a delivery retry stay queued for the next start.
+func (p *Pipeline) Start(ctx context.Context) error {
+	err := p.prepare(ctx)
 	if err != nil {
 		return err
 	}
 
-	logger.Info("start listening messages")
+	work, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+	drainQueue := p.startQueue(work)
+	defer drainQueue()
 
-	for msg := range p.client.ListenNewMessages() {
-		text, ok, err := p.filterMessage(bot.Id, msg)
-		if err != nil {
-			logger.Errorf("message filter failed. msg: %s. %+v", msg, err)
+	// new messages queue up while backfill runs, the default buffer could drop them
+	var subOpts tgclient.SubscribeOptions
+	if p.backfillOpts != nil {
+		subOpts.BufferSize = backfillUpdateBuffer
+	}
+	sub := p.client.SubscribeWithOptions(tgclient.NewMessageUpdateType, subOpts)
+	defer sub.Unsubscribe()
+	contentSub := p.client.Subscribe(tgclient.MessageContentUpdateType)
+	defer contentSub.Unsubscribe()
+	editedSub := p.client.Subscribe(tgclient.MessageEditedUpdateType)
+	defer editedSub.Unsubscribe()
+	deleteSub := p.client.Subscribe(tgclient.DeleteMessagesUpdateType)
+	defer deleteSub.Unsubscribe()
+
+	if p.backfillOpts != nil {
+		err = p.backfill(ctx, *p.backfillOpts)
+		if err != nil && ctx.Err() == nil {
+			p.logger.Errorf("backfill failed. %+v", err)
 		}
-		if ok {
-			err = p.bot.SendMessage(int64(me.Id), text)
-			if err != nil {
-				logger.Errorf("message repost failed. msg: %s. %+v", msg, err)
+	}
+
+	p.logger.Info("start listening messages")
+
+	events := sub.Events()
+	contents := contentSub.Events()
+	edits := editedSub.Events()
+	deletes := deleteSub.Events()
+	stopped := ctx.Done()
+
+	for {
+		select {
+		case <-stopped:
+			p.logger.Info("stop listening messages, draining")
+			stopped = nil
+			sub.Unsubscribe()
+			contentSub.Unsubscribe()
+			editedSub.Unsubscribe()
+			deleteSub.Unsubscribe()
+			contents, edits, deletes = nil, nil, nil
+			time.AfterFunc(p.drainTimeout, cancelWork)
+		case albumId := <-p.albums.ready:
+			if work.Err() != nil {
+				return DrainErr.New("drain timeout exceeded")
+			}
+			if album := p.albums.take(albumId); album != nil {
+				p.handlePost(work, album)
+			}
+		case ev, ok := <-events:
+			if !ok {
+				return p.drain(work, drainQueue)
+			}
+			if work.Err() != nil {
+				return DrainErr.New("drain timeout exceeded")
+			}
+			p.handleEvent(work, ev)
+		case ev, ok := <-contents:
+			if !ok {
+				contents = nil
+				continue
 			}
-			logger.Info("message repost ", msg)
+			p.handleEditEvent(work, ev)
+		case ev, ok := <-edits:
+			if !ok {
+				edits = nil
+				continue
+			}
+			p.handleEditEvent(work, ev)
+		case ev, ok := <-deletes:
+			if !ok {
+				deletes = nil
+				continue
+			}
+			p.handleDeleteEvent(work, ev)
 		}
 	}
+}
 
+// prepare resolves rules and loads the runtime state.
+func (p *Pipeline) prepare(ctx context.Context) error {
+	bot, err := p.bot.GetMeContext(ctx)
+	if err != nil {
+		return err
+	}
+	me, err := p.client.GetMeContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	resolver := newChatResolver(p.client, p.bot)
+	for _, r := range p.rules {
+		err = resolver.resolveRule(ctx, r, int64(me.Id))
+		if err != nil {
+			return err
+		}
+		p.logger.Infof("rule %s: sources: %v, destinations: %v", r.name, r.conf.Sources, r.destinations)
+	}
+	p.mu.Lock()
+	p.resolver = resolver
+	p.ownerId = int64(me.Id)
+	p.botId = bot.Id
+	p.mu.Unlock()
+	p.loadState(ctx)
 	return nil
 }
 
-func (p *Pipeline) filterMessage(botId int32, msg tgclient.Message) (txt string, ok bool, err error) {
-	if msg.SenderUserId == botId {
+// drain queues collected albums and delivers queued posts which are due.
+func (p *Pipeline) drain(ctx context.Context, drainQueue func()) error {
+	for _, album := range p.albums.flushAll() {
+		if ctx.Err() != nil {
+			return DrainErr.New("drain timeout exceeded")
+		}
+		p.handlePost(ctx, album)
+	}
+	drainQueue()
+	if ctx.Err() != nil {
+		return DrainErr.New("drain timeout exceeded")
+	}
+	p.logger.Infof("pipeline stopped, queued: %d", p.queue.size())
+	return nil
+}
+
+func (p *Pipeline) handleEvent(ctx context.Context, ev tgclient.Event) {
+	update := tgclient.NewMessageUpdate{}
+	err := ev.Unmarshal(&update)
+	if err != nil {
+		p.logger.Errorf("%+v", err)
+		return
+	}
+	msg := update.Message
+	if p.isSourceChat(msg.ChatId) {
+		if msg.Id <= p.lastSeen.get(msg.ChatId) {
+			p.logger.Debugf("message already seen, skipped. msg: %s", msg)
+			return
+		}
+		p.markSeen(msg)
+	}
+
+	if p.isPaused() {
+		p.logger.Debugf("pipeline paused, message skipped. msg: %s", msg)
+		return
+	}
+
+	pt, ok, err := p.filterMessage(ctx, p.botId, msg)
+	if err != nil {
+		p.logger.Errorf("message filter failed. msg: %s. %+v", msg, err)
+	}
+	if !ok {
+		return
+	}
+	if msg.MediaAlbumId != 0 {
+		p.albums.add(msg.MediaAlbumId, pt)
+		return
+	}
+	p.handlePost(ctx, &post{parts: []part{pt}})
+}
+
+func (p *Pipeline) handlePost(ctx context.Context, pst *post) {
+	main := pst.main()
+	msg := main.in.Message
+	text := main.in.Text
+
+	for _, r := range p.routePost(ctx, pst) {
+		if r.rule.mode != DeliveryForward && pst.isEmpty() {
+			p.logger.Debugf("message without content skipped. rule: %s, msg: %s", r.rule.name, msg)
+			continue
+		}
+		if p.destinations.isDisabled(r.dest) {
+			p.logger.Debugf("disabled destination skipped. dest: %d, msg: %s", r.dest, msg)
+			continue
+		}
+		if p.mutes.isMuted(r.dest, msg.ChatId, int64(msg.SenderUserId)) {
+			p.logger.Debugf("muted message skipped. dest: %d, msg: %s", r.dest, msg)
+			continue
+		}
+		if p.dedup != nil && p.dedup.isReposted(r.dest, msg.ChatId, msg.Id, text, "") {
+			p.logger.Infof("message already reposted. dest: %d, msg: %s", r.dest, msg)
+			continue
+		}
+		p.enqueue(r, pst)
+	}
+}
+
+// isSourceChat reports whether the chat is an explicit source of a rule.
+func (p *Pipeline) isSourceChat(chatId int64) bool {
+	for _, r := range p.currentRules() {
+		if r.sources[chatId] {
+			return true
+		}
+	}
+	return false
+}
+
+// routePost routes a single message or album: destinations of all rules matching any part of it.
+// Destinations are deduplicated per post, so an album matched by several parts is delivered once.
+func (p *Pipeline) routePost(ctx context.Context, pst *post) []route {
+	var routes []route
+	seen := map[int64]bool{}
+	for _, pt := range pst.parts {
+		for _, r := range p.routeMessage(ctx, pt.in) {
+			if !seen[r.dest] {
+				seen[r.dest] = true
+				routes = append(routes, r)
+			}
+		}
+	}
+	return routes
+}
+
+// routeMessage returns destinations of all rules matching the message.
+// A destination matched by several rules is delivered by the first one.
+func (p *Pipeline) routeMessage(ctx context.Context, in *filter.Input) []route {
+	var routes []route
+	seen := map[int64]bool{}
+	for _, r := range p.currentRules() {
+		if !p.matchRule(ctx, r, in) {
+			continue
+		}
+		dests := r.destinations
+		if r.topic {
+			dests = p.subs.subscribers(r.name)
+		}
+		for _, dest := range dests {
+			if !seen[dest] {
+				seen[dest] = true
+				routes = append(routes, route{dest: dest, rule: r})
+			}
+		}
+	}
+	return routes
+}
+
+// matchRule checks the rule against the message, the chat and sender are loaded only for filters using them.
+func (p *Pipeline) matchRule(ctx context.Context, r *rule, in *filter.Input) bool {
+	if !r.matchChat(in.Message.ChatId) || !r.re.MatchString(in.Text) {
+		return false
+	}
+	if r.filter.UsesMetadata() {
+		p.loadMetadata(ctx, in)
+	}
+	return r.filter.Match(in)
+}
+
+// isRuleChat reports whether any rule reposts messages of the chat.
+func (p *Pipeline) isRuleChat(chatId int64) bool {
+	for _, r := range p.currentRules() {
+		if r.matchChat(chatId) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterMessage skips own bot messages and messages of chats no rule reposts, and collects the message content.
+// The chat and sender metadata are loaded later by loadMetadata.
+func (p *Pipeline) filterMessage(ctx context.Context, botId int64, msg tgclient.Message) (pt part, ok bool, err error) {
+	if int64(msg.SenderUserId) == botId || !p.isRuleChat(msg.ChatId) {
 		return
 	}
 	//if msg.IsOutgoing {
@@ -62,15 +390,52 @@ func (p *Pipeline) filterMessage(botId int32, msg tgclient.Message) (txt string,
 	if err != nil {
 		return
 	}
-	if classType != tgclient.MessageTextType {
-		return
-	}
-	msgText := tgclient.MessageText{}
-	err = msg.UnmarshalContent(&msgText)
+	content, err := msg.Content()
 	if err != nil {
 		return
 	}
-	txt = msgText.Text.Text
-	ok = p.re.MatchString(msgText.Text.Text)
+	in := &filter.Input{
+		Message:     msg,
+		ContentType: classType,
+		Text:        tgclient.ContentText(content).Text,
+	}
+	pt = part{in: in, content: content}
+	ok = true
 	return
 }
+
+// loadMetadata loads the chat and sender of the message once, failed loads leave them empty.
+func (p *Pipeline) loadMetadata(ctx context.Context, in *filter.Input) {
+	if in.HasMetadata {
+		return
+	}
+	in.HasMetadata = true
+	msg := in.Message
+	var err error
+	in.Chat, err = p.client.GetChatContext(ctx, msg.ChatId)
+	if err != nil {
+		p.logger.Warnf("chat load failed. chat: %d. %+v", msg.ChatId, err)
+	}
+	in.ChatUsername, err = p.chatUsername(ctx, in.Chat)
+	if err != nil {
+		p.logger.Warnf("chat username load failed. chat: %d. %+v", msg.ChatId, err)
+	}
+	if msg.SenderUserId != 0 {
+		in.Sender, err = p.client.GetUserContext(ctx, msg.SenderUserId)
+		if err != nil {
+			p.logger.Warnf("sender load failed. user: %d. %+v", msg.SenderUserId, err)
+		}
+	}
+}
+
+func (p *Pipeline) chatUsername(ctx context.Context, chat tgclient.Chat) (string, error) {
+	switch chat.Type.Type {
+	case tgclient.ChatTypeSupergroupType:
+		group, err := p.client.GetSupergroupContext(ctx, chat.Type.SupergroupId)
+		return group.Username, err
+	case tgclient.ChatTypePrivateType:
+		user, err := p.client.GetUserContext(ctx, chat.Type.UserId)
+		return user.UserName, err
+	}
+	return "", nil
+}
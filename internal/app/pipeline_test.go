@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"tg-reposter/pkg/tgclient"
+)
+
+func textMessage(chatId int64, text string) tgclient.Message {
+	content, _ := json.Marshal(map[string]interface{}{
+		"@type": tgclient.MessageTextType,
+		"text":  tgclient.FormattedText{Text: text},
+	})
+	return tgclient.Message{Id: 1 << 20, ChatId: chatId, SenderUserId: 42, RawContent: content}
+}
+
+// TestFilterMessageSkipsMetadata checks messages are filtered without chat and sender requests,
+// the pipeline has no client and would panic on any.
+func TestFilterMessageSkipsMetadata(t *testing.T) {
+	p, err := NewPipeline([]RuleConfig{{Name: "go", Filter: `keywords("go")`}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.rules[0].sources = map[int64]bool{1: true}
+	ctx := context.Background()
+
+	if _, ok, _ := p.filterMessage(ctx, 0, textMessage(2, "go")); ok {
+		t.Fatal("message of a chat without rules passed")
+	}
+	pt, ok, err := p.filterMessage(ctx, 0, textMessage(1, "go"))
+	if !ok || err != nil {
+		t.Fatalf("message skipped: %v", err)
+	}
+	if routes := p.routeMessage(ctx, pt.in); len(routes) != 0 {
+		t.Fatalf("routes without destinations: %+v", routes)
+	}
+	if !p.matchRule(ctx, p.rules[0], pt.in) || pt.in.HasMetadata {
+		t.Fatalf("rule match: %+v", pt.in)
+	}
+}
+
+func TestRenderHighlight(t *testing.T) {
+	conf := RuleConfig{Name: "go", FilterRegex: `(?i)go`, Highlight: "bold"}
+	p, err := NewPipeline([]RuleConfig{conf}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.rules[0].sources = map[int64]bool{1: true}
+	pt, ok, _ := p.filterMessage(context.Background(), 0, textMessage(1, "😀 Go & go"))
+	if !ok {
+		t.Fatal("message skipped")
+	}
+
+	cases := []struct {
+		format   TextFormat
+		template string
+		text     string
+	}{
+		{FormatEntities, "", "😀 Go & go"},
+		{FormatHTML, "", "😀 <b>Go</b> &amp; <b>go</b>"},
+		{FormatMarkdown, "", `😀 *Go* & *go*`},
+		{FormatHTML, "{{.Rule}}: {{.Text}}", "go: 😀 <b>Go</b> &amp; <b>go</b>"},
+		{FormatMarkdown, "{{.Text}}", `😀 *Go* & *go*`},
+	}
+	for _, c := range cases {
+		conf.Format, conf.Template = c.format, c.template
+		r, err := compileRule(conf)
+		if err != nil {
+			t.Fatalf("%s %q: %v", c.format, c.template, err)
+		}
+		text, err := r.render(pt)
+		if err != nil || text.text != c.text {
+			t.Errorf("%s %q: got %q, want %q. %v", c.format, c.template, text.text, c.text, err)
+		}
+	}
+
+	conf.Format, conf.Template = FormatEntities, ""
+	r, _ := compileRule(conf)
+	text, _ := r.render(pt)
+	if len(text.entities) != 2 || text.entities[0].Offset != 3 || text.entities[1].Offset != 8 {
+		t.Fatalf("entities: %+v", text.entities)
+	}
+
+	for _, c := range []struct {
+		format   TextFormat
+		template string
+	}{{FormatPlain, ""}, {FormatPlain, "{{.Text}}"}, {FormatEntities, "{{.Text}}"}} {
+		conf.Format, conf.Template = c.format, c.template
+		if _, err = compileRule(conf); err == nil {
+			t.Errorf("%s %q: highlight accepted", c.format, c.template)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"sort"
+	"sync"
+	"tg-reposter/internal/filter"
+	"tg-reposter/internal/format"
+	"tg-reposter/pkg/tgclient"
+	"time"
+)
+
+// albumWait is how long to wait for the next part of an album before reposting it.
+const albumWait = 2 * time.Second
+
+// part is a source message with its typed content.
+type part struct {
+	in      *filter.Input
+	content interface{}
+}
+
+func (pt part) text() format.Text {
+	return format.FromTd(tgclient.ContentText(pt.content))
+}
+
+func (pt part) file() (tgclient.File, bool) {
+	return tgclient.ContentFile(pt.content)
+}
+
+// post is a single message or an album of messages reposted together.
+type post struct {
+	parts []part
+}
+
+// main returns the part carrying the post text, the first part if there is none.
+func (pst *post) main() part {
+	for _, pt := range pst.parts {
+		if pt.in.Text != "" {
+			return pt
+		}
+	}
+	return pst.parts[0]
+}
+
+// isText reports whether the post is a plain text message.
+func (pst *post) isText() bool {
+	_, ok := pst.parts[0].content.(*tgclient.MessageText)
+	return len(pst.parts) == 1 && ok
+}
+
+func (pst *post) isSticker() bool {
+	_, ok := pst.parts[0].content.(*tgclient.MessageSticker)
+	return len(pst.parts) == 1 && ok
+}
+
+func (pst *post) isEmpty() bool {
+	for _, pt := range pst.parts {
+		if _, ok := pt.file(); ok || pt.in.Text != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// albumCollector groups album parts, arriving as separate updates, into posts.
+// An album is ready when no new parts arrive within wait.
+type albumCollector struct {
+	wait  time.Duration
+	ready chan int64
+	done  chan struct{}
+	once  sync.Once
+
+	mu      sync.Mutex
+	pending map[int64]*post
+	timers  map[int64]*time.Timer
+}
+
+func newAlbumCollector(wait time.Duration) *albumCollector {
+	return &albumCollector{
+		wait:    wait,
+		ready:   make(chan int64),
+		done:    make(chan struct{}),
+		pending: map[int64]*post{},
+		timers:  map[int64]*time.Timer{},
+	}
+}
+
+func (c *albumCollector) add(albumId int64, pt part) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pst, ok := c.pending[albumId]
+	if !ok {
+		pst = &post{}
+		c.pending[albumId] = pst
+	}
+	pst.parts = append(pst.parts, pt)
+
+	if timer, ok := c.timers[albumId]; ok {
+		timer.Stop()
+	}
+	c.timers[albumId] = time.AfterFunc(c.wait, func() {
+		select {
+		case c.ready <- albumId:
+		case <-c.done:
+		}
+	})
+}
+
+func (c *albumCollector) take(albumId int64) *post {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pst, ok := c.pending[albumId]
+	if !ok {
+		return nil
+	}
+	delete(c.pending, albumId)
+	delete(c.timers, albumId)
+	sortParts(pst)
+	return pst
+}
+
+// flushAll stops the collector and returns all pending albums.
+func (c *albumCollector) flushAll() []*post {
+	c.once.Do(func() {
+		close(c.done)
+	})
+
+	c.mu.Lock()
+	ids := make([]int64, 0, len(c.pending))
+	for id, timer := range c.timers {
+		timer.Stop()
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+
+	var res []*post
+	for _, id := range ids {
+		if pst := c.take(id); pst != nil {
+			res = append(res, pst)
+		}
+	}
+	return res
+}
+
+func sortParts(pst *post) {
+	sort.Slice(pst.parts, func(i, j int) bool {
+		return pst.parts[i].in.Message.Id < pst.parts[j].in.Message.Id
+	})
+}
@@ -0,0 +1,568 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"tg-reposter/pkg/kvstore"
+	"tg-reposter/pkg/tgbot"
+	"tg-reposter/pkg/tgclient"
+	"time"
+)
+
+const queueBucket = "queue"
+
+const (
+	queueBackoff          = time.Second
+	queueMaxBackoff       = 10 * time.Minute
+	defaultQueueAttempts  = 10
+	defaultDeadLetterPath = "reposter.dead.jsonl"
+)
+
+// QueueOptions bound delivery retries. Items failing MaxAttempts times or with a permanent error
+// are moved to the dead-letter file, one JSON item per line.
+type QueueOptions struct {
+	MaxAttempts    int
+	DeadLetterPath string
+}
+
+// queueItem is a delivery of a post to one destination. Source messages are loaded again
+// when the item is delivered after a restart.
+type queueItem struct {
+	Id       string    `json:"id"`
+	Seq      int64     `json:"seq"`
+	Rule     string    `json:"rule"`
+	Dest     int64     `json:"dest"`
+	ChatId   int64     `json:"chatId"`
+	Messages []int64   `json:"messages"`
+	Attempts int       `json:"attempts"`
+	NextAt   time.Time `json:"nextAt"`
+	Error    string    `json:"error,omitempty"`
+	FailedAt time.Time `json:"failedAt,omitempty"`
+	// Content is the content hash reserved in dedup until the item is delivered or dead
+	Content string `json:"content,omitempty"`
+}
+
+func newQueueItem(r route, pst *post) *queueItem {
+	main := pst.main().in.Message
+	item := &queueItem{
+		Id:     destKey(r.dest, sourceKey(main.ChatId, main.Id)),
+		Rule:   r.rule.name,
+		Dest:   r.dest,
+		ChatId: main.ChatId,
+		NextAt: time.Now(),
+	}
+	for _, pt := range pst.parts {
+		item.Messages = append(item.Messages, pt.in.Message.Id)
+	}
+	return item
+}
+
+// queue is the outbound queue of the pipeline, it survives restarts when a store is set.
+type queue struct {
+	store *kvstore.Store
+	opts  QueueOptions
+	mu    sync.Mutex
+	items map[string]*queueItem
+	// posts are kept for items queued by this process to skip loading source messages
+	posts map[string]*post
+	seq   int64
+	wake  chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{
+		opts:  QueueOptions{MaxAttempts: defaultQueueAttempts, DeadLetterPath: defaultDeadLetterPath},
+		items: map[string]*queueItem{},
+		posts: map[string]*post{},
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+func (q *queue) load(store *kvstore.Store) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.store = store
+	for _, key := range store.Keys(queueBucket) {
+		item := &queueItem{}
+		_, err := store.GetJSON(queueBucket, key, item)
+		if err != nil {
+			return err
+		}
+		q.items[key] = item
+		if item.Seq > q.seq {
+			q.seq = item.Seq
+		}
+	}
+	return nil
+}
+
+// push queues an item unless it is already queued.
+func (q *queue) push(item *queueItem, pst *post) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.items[item.Id]; ok {
+		return false, nil
+	}
+	q.seq++
+	item.Seq = q.seq
+	err := q.save(item)
+	if err != nil {
+		return false, err
+	}
+	q.items[item.Id] = item
+	if pst != nil {
+		q.posts[item.Id] = pst
+	}
+	q.notify()
+	return true, nil
+}
+
+// next returns the earliest due item in queue order, or how long to wait for one, 0 if the queue is empty.
+func (q *queue) next(now time.Time) (item *queueItem, pst *post, wait time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, it := range q.items {
+		if it.NextAt.After(now) {
+			if d := it.NextAt.Sub(now); wait == 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		if item == nil || it.Seq < item.Seq {
+			item = it
+		}
+	}
+	if item == nil {
+		return nil, nil, wait
+	}
+	copied := *item
+	return &copied, q.posts[item.Id], 0
+}
+
+func (q *queue) retry(item *queueItem, cause error, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item.NextAt = time.Now().Add(delay)
+	item.Error = cause.Error()
+	if _, ok := q.items[item.Id]; !ok {
+		return nil
+	}
+	q.items[item.Id] = item
+	return q.save(item)
+}
+
+func (q *queue) remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.items, id)
+	delete(q.posts, id)
+	if q.store == nil {
+		return nil
+	}
+	return q.store.Delete(queueBucket, id)
+}
+
+// dead moves an item to the dead-letter file.
+func (q *queue) dead(item *queueItem, cause error) error {
+	item.Error = cause.Error()
+	item.FailedAt = time.Now()
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return QueueErr.Wrap(err, "dead letter encode failed. item: %s", item.Id)
+	}
+	f, err := os.OpenFile(q.opts.DeadLetterPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return QueueErr.Wrap(err, "dead letter file open failed")
+	}
+	_, err = f.Write(append(raw, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return QueueErr.Wrap(err, "dead letter write failed. item: %s", item.Id)
+	}
+	return q.remove(item.Id)
+}
+
+// requeueDead queues dead letters again with reset attempts. Dead letters of items already queued
+// are kept in the dead-letter file, the others are removed from it.
+func (q *queue) requeueDead() (int, error) {
+	f, err := os.Open(q.opts.DeadLetterPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, QueueErr.Wrap(err, "dead letter file open failed")
+	}
+	var items []*queueItem
+	lines := map[*queueItem][]byte{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		item := &queueItem{}
+		err = json.Unmarshal(scanner.Bytes(), item)
+		if err != nil {
+			_ = f.Close()
+			return 0, QueueErr.Wrap(err, "invalid dead letter. line: %d", line)
+		}
+		items = append(items, item)
+		lines[item] = append([]byte(nil), scanner.Bytes()...)
+	}
+	err = scanner.Err()
+	_ = f.Close()
+	if err != nil {
+		return 0, QueueErr.Wrap(err, "dead letter file read failed")
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Seq < items[j].Seq
+	})
+	count := 0
+	var kept [][]byte
+	var pushErr error
+	for _, item := range items {
+		if pushErr != nil {
+			kept = append(kept, lines[item])
+			continue
+		}
+		item.Attempts, item.NextAt, item.Error, item.FailedAt = 0, time.Now(), "", time.Time{}
+		ok, err := q.push(item, nil)
+		if err != nil {
+			pushErr = err
+		}
+		if ok {
+			count++
+		} else {
+			kept = append(kept, lines[item])
+		}
+	}
+	err = q.rewriteDead(kept)
+	if pushErr != nil {
+		return count, pushErr
+	}
+	return count, err
+}
+
+// rewriteDead replaces the dead-letter file with the lines.
+func (q *queue) rewriteDead(lines [][]byte) error {
+	tmp := q.opts.DeadLetterPath + ".tmp"
+	var data []byte
+	for _, line := range lines {
+		data = append(append(data, line...), '\n')
+	}
+	err := ioutil.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, q.opts.DeadLetterPath)
+	}
+	if err != nil {
+		return QueueErr.Wrap(err, "dead letter file rewrite failed")
+	}
+	return nil
+}
+
+func (q *queue) size() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *queue) save(item *queueItem) error {
+	if q.store == nil {
+		return nil
+	}
+	return q.store.PutJSON(queueBucket, item.Id, item)
+}
+
+func (q *queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SetQueue overrides delivery retry options.
+func (p *Pipeline) SetQueue(opts QueueOptions) {
+	if opts.MaxAttempts > 0 {
+		p.queue.opts.MaxAttempts = opts.MaxAttempts
+	}
+	if opts.DeadLetterPath != "" {
+		p.queue.opts.DeadLetterPath = opts.DeadLetterPath
+	}
+}
+
+// Replay queues dead letters again and delivers them. Items failing again go back to the dead-letter file,
+// items waiting for a retry are delivered on the next start.
+func (p *Pipeline) Replay(ctx context.Context) error {
+	err := p.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	count, err := p.queue.requeueDead()
+	if err != nil {
+		return err
+	}
+	p.logger.Infof("dead letters queued: %d", count)
+
+	drainQueue := p.startQueue(ctx)
+	drainQueue()
+	p.logger.Infof("replay finished, queued: %d", p.queue.size())
+	return ctx.Err()
+}
+
+// enqueue queues delivery of the post to the route destination.
+// The content is reserved in dedup, so copies of it posted before the delivery are skipped.
+func (p *Pipeline) enqueue(r route, pst *post) {
+	msg := pst.main().in.Message
+	item := newQueueItem(r, pst)
+	if p.dedup != nil {
+		item.Content = contentHash(pst.main().in.Text)
+	}
+	ok, err := p.queue.push(item, pst)
+	if err != nil {
+		p.logger.Errorf("message queue failed. dest: %d, msg: %s. %+v", r.dest, msg, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	p.logger.Debugf("message queued. rule: %s, dest: %d, msg: %s", r.rule.name, r.dest, msg)
+	if p.dedup != nil {
+		err = p.dedup.reserve(r.dest, item.Content, item.Id)
+		if err != nil {
+			p.logger.Errorf("dedup store failed. msg: %s. %+v", msg, err)
+		}
+	}
+}
+
+// startQueue delivers queued posts in the background until ctx is done. The returned func
+// stops it once no item is due and waits for it, items waiting for a retry stay queued.
+func (p *Pipeline) startQueue(ctx context.Context) func() {
+	drain := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.runQueue(ctx, drain)
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(drain)
+			<-done
+		})
+	}
+}
+
+func (p *Pipeline) runQueue(ctx context.Context, drain <-chan struct{}) {
+	draining := false
+	for ctx.Err() == nil {
+		item, pst, wait := p.queue.next(time.Now())
+		if item != nil {
+			p.deliverItem(ctx, item, pst)
+			continue
+		}
+		if draining {
+			return
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-p.queue.wake:
+		case <-timeout:
+		case <-drain:
+			draining = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// deliverItem delivers a queued item. Temporary failures are retried with exponential backoff
+// and jitter, other failures and items out of attempts are moved to the dead-letter file.
+func (p *Pipeline) deliverItem(ctx context.Context, item *queueItem, pst *post) {
+	r := p.findRule(item.Rule)
+	if r == nil {
+		p.deadItem(item, QueueErr.New("rule removed: %s", item.Rule))
+		return
+	}
+	if p.destinations.isDisabled(item.Dest) {
+		p.logger.Debugf("disabled destination skipped. dest: %d, item: %s", item.Dest, item.Id)
+		p.removeItem(item)
+		return
+	}
+	if pst == nil {
+		var err error
+		pst, err = p.loadPost(ctx, item)
+		if tgclient.IsNotFound(err) {
+			p.logger.Warnf("queued message dropped, source message deleted. item: %s", item.Id)
+			p.removeItem(item)
+			return
+		}
+		if err != nil {
+			p.retryItem(item, err, 0)
+			return
+		}
+		if pst == nil {
+			p.logger.Warnf("queued message dropped, source message not supported. item: %s", item.Id)
+			p.removeItem(item)
+			return
+		}
+	}
+
+	rt := route{dest: item.Dest, rule: r}
+	msg := pst.main().in.Message
+	if p.dedup != nil && p.dedup.isReposted(item.Dest, msg.ChatId, msg.Id, pst.main().in.Text, item.Id) {
+		p.logger.Infof("message already reposted, queued copy dropped. dest: %d, msg: %s", item.Dest, msg)
+		p.removeItem(item)
+		return
+	}
+	ref, err := p.deliver(ctx, rt, pst)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, ok := tgbot.AsApiError(err); ok && !tgbot.IsTemporary(err) {
+			p.handleDeliveryError(rt, pst, err)
+			p.deadItem(item, err)
+			return
+		}
+		p.retryItem(item, err, tgbot.RetryAfter(err))
+		return
+	}
+
+	p.removeItem(item)
+	p.recordCopy(pst, ref)
+	p.logger.Infof("message repost. rule: %s, mode: %s, dest: %d, parts: %d, msg: %s",
+		r.name, r.mode, item.Dest, len(pst.parts), msg)
+	if p.dedup != nil {
+		err = p.dedup.markReposted(item.Dest, msg.ChatId, msg.Id, pst.main().in.Text)
+		if err != nil {
+			p.logger.Errorf("dedup store failed. msg: %s. %+v", msg, err)
+		}
+	}
+}
+
+// handleDeliveryError handles permanent Bot API errors: disables destinations the bot may not write to
+// and logs why the post is dropped.
+func (p *Pipeline) handleDeliveryError(r route, pst *post, err error) {
+	msg := pst.main().in.Message
+	apiErr, _ := tgbot.AsApiError(err)
+	switch {
+	case tgbot.IsForbidden(err) && r.rule.topic:
+		topics, storeErr := p.subs.unsubscribeAll(r.dest)
+		if storeErr != nil {
+			p.logger.Errorf("subscription remove failed. chat: %d. %+v", r.dest, storeErr)
+		}
+		p.logger.Warnf("chat unsubscribed, bot has no access. chat: %d, topics: %v. %v", r.dest, topics, err)
+	case tgbot.IsForbidden(err):
+		p.logger.Errorf("destination disabled, bot has no access. dest: %d. %v", r.dest, err)
+		if storeErr := p.destinations.disable(r.dest, err.Error()); storeErr != nil {
+			p.logger.Errorf("disabled destination store failed. dest: %d. %+v", r.dest, storeErr)
+		}
+	case tgbot.IsNotFound(err):
+		p.logger.Warnf("message repost dropped, chat or message not found. dest: %d, msg: %s. %v", r.dest, msg, err)
+	default:
+		p.logger.Warnf("message repost dropped. dest: %d, code: %d, msg: %s. %v", r.dest, apiErr.Code, msg, err)
+	}
+}
+
+// loadPost loads source messages of an item queued before a restart, nil means they are no longer reposted.
+func (p *Pipeline) loadPost(ctx context.Context, item *queueItem) (*post, error) {
+	pst := &post{}
+	for _, id := range item.Messages {
+		msg, err := p.client.GetMessageContext(ctx, item.ChatId, id)
+		if err != nil {
+			return nil, err
+		}
+		pt, ok, err := p.filterMessage(ctx, p.botId, msg)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			pst.parts = append(pst.parts, pt)
+		}
+	}
+	if len(pst.parts) == 0 {
+		return nil, nil
+	}
+	return pst, nil
+}
+
+func (p *Pipeline) retryItem(item *queueItem, cause error, retryAfter time.Duration) {
+	item.Attempts++
+	if item.Attempts >= p.queue.opts.MaxAttempts {
+		p.logger.Errorf("message repost failed %d times. dest: %d, item: %s. %+v", item.Attempts, item.Dest, item.Id, cause)
+		p.deadItem(item, cause)
+		return
+	}
+	delay := retryDelay(item.Attempts)
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	p.logger.Warnf("message repost failed, retry in %s. dest: %d, attempt: %d. %v", delay, item.Dest, item.Attempts, cause)
+	err := p.queue.retry(item, cause, delay)
+	if err != nil {
+		p.logger.Errorf("queue store failed. item: %s. %+v", item.Id, err)
+	}
+}
+
+// deadItem moves the item to dead letters. If the dead-letter file can not be written, the item stays
+// queued and is retried after a backoff instead of being due again at once.
+func (p *Pipeline) deadItem(item *queueItem, cause error) {
+	err := p.queue.dead(item, cause)
+	if err != nil {
+		delay := retryDelay(item.Attempts + 1)
+		p.logger.Errorf("dead letter store failed, retry in %s. item: %s. %+v", delay, item.Id, err)
+		err = p.queue.retry(item, cause, delay)
+		if err != nil {
+			p.logger.Errorf("queue store failed. item: %s. %+v", item.Id, err)
+		}
+		return
+	}
+	p.logger.Warnf("message moved to dead letters. dest: %d, item: %s", item.Dest, item.Id)
+	if p.dedup != nil {
+		err = p.dedup.release(item.Dest, item.Content, item.Id)
+		if err != nil {
+			p.logger.Errorf("dedup store failed. item: %s. %+v", item.Id, err)
+		}
+	}
+}
+
+func (p *Pipeline) removeItem(item *queueItem) {
+	err := p.queue.remove(item.Id)
+	if err != nil {
+		p.logger.Errorf("queue store failed. item: %s. %+v", item.Id, err)
+	}
+}
+
+// retryDelay doubles the backoff with every attempt, a random half of it is the jitter.
+func retryDelay(attempt int) time.Duration {
+	delay := queueMaxBackoff
+	if attempt < 20 {
+		delay = queueBackoff << uint(attempt-1)
+		if delay > queueMaxBackoff {
+			delay = queueMaxBackoff
+		}
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
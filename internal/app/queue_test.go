@@ -0,0 +1,234 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"tg-reposter/pkg/tgbot"
+	"time"
+)
+
+func TestQueueOrderAndPersistence(t *testing.T) {
+	store := openTestStore(t)
+	q := newQueue()
+	if err := q.load(store); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		ok, err := q.push(&queueItem{Id: id, NextAt: time.Now()}, nil)
+		if !ok || err != nil {
+			t.Fatalf("push %s: %v, %v", id, ok, err)
+		}
+	}
+	if ok, _ := q.push(&queueItem{Id: "a"}, nil); ok {
+		t.Fatal("duplicate item queued")
+	}
+
+	item, _, _ := q.next(time.Now())
+	if item.Id != "a" {
+		t.Fatalf("next: %s", item.Id)
+	}
+	if err := q.retry(item, errors.New("flood"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if item, _, _ = q.next(time.Now()); item.Id != "b" {
+		t.Fatalf("next after retry: %s", item.Id)
+	}
+
+	restored := newQueue()
+	if err := restored.load(store); err != nil {
+		t.Fatal(err)
+	}
+	if restored.size() != 3 {
+		t.Fatalf("restored size: %d", restored.size())
+	}
+	if item, _, _ = restored.next(time.Now()); item.Id != "b" {
+		t.Fatalf("restored next: %s", item.Id)
+	}
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	q := newQueue()
+	q.opts.DeadLetterPath = filepath.Join(t.TempDir(), "dead.jsonl")
+	_, _ = q.push(&queueItem{Id: "a", NextAt: time.Now()}, nil)
+	item, _, _ := q.next(time.Now())
+	if err := q.dead(item, errors.New("forbidden")); err != nil {
+		t.Fatal(err)
+	}
+	if q.size() != 0 {
+		t.Fatalf("dead item still queued")
+	}
+
+	count, err := q.requeueDead()
+	if err != nil || count != 1 {
+		t.Fatalf("requeue: %d, %v", count, err)
+	}
+	raw, _ := ioutil.ReadFile(q.opts.DeadLetterPath)
+	if len(raw) != 0 {
+		t.Fatalf("dead letters not truncated: %s", raw)
+	}
+	if item, _, _ = q.next(time.Now()); item == nil || item.Attempts != 0 || item.Error != "" {
+		t.Fatalf("requeued item: %+v", item)
+	}
+}
+
+func TestDeadItemBacksOffOnWriteFailure(t *testing.T) {
+	p := &Pipeline{queue: newQueue(), logger: logrus.WithField("logger", "test")}
+	p.queue.opts.DeadLetterPath = filepath.Join(t.TempDir(), "missing", "dead.jsonl")
+	_, _ = p.queue.push(&queueItem{Id: "a", NextAt: time.Now()}, nil)
+
+	item, _, _ := p.queue.next(time.Now())
+	p.deadItem(item, errors.New("forbidden"))
+	if p.queue.size() != 1 {
+		t.Fatalf("item dropped")
+	}
+	if item, _, wait := p.queue.next(time.Now()); item != nil || wait <= 0 {
+		t.Fatalf("item is due again: %+v", item)
+	}
+}
+
+// newTestDelivery returns a pipeline reposting everything to dest 100 through a test Bot API server
+// and a func returning texts of sent messages.
+func newTestDelivery(t *testing.T) (*Pipeline, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		sent = append(sent, req["text"].(string))
+		id := len(sent)
+		mu.Unlock()
+		_, _ = fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"chat":{"id":100}}}`, id)
+	}))
+	t.Cleanup(srv.Close)
+
+	bot, err := tgbot.NewBuilder().ApiUrl(srv.URL).Token("T").NoRateLimits().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPipeline([]RuleConfig{{Name: "all"}}, nil, bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.rules[0].destinations = []int64{100}
+	store := openTestStore(t)
+	if err = p.SetStore(store); err != nil {
+		t.Fatal(err)
+	}
+	p.SetDedup(store, time.Hour)
+	return p, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), sent...)
+	}
+}
+
+func TestQueueSkipsDuplicateContent(t *testing.T) {
+	p, sent := newTestDelivery(t)
+	ctx := context.Background()
+
+	for _, chatId := range []int64{1, 2} {
+		pt, ok, _ := p.filterMessage(ctx, 0, textMessage(chatId, "Release 1.0"))
+		if !ok {
+			t.Fatal("message skipped")
+		}
+		p.handlePost(ctx, &post{parts: []part{pt}})
+	}
+	if p.queue.size() != 1 {
+		t.Fatalf("queued: %d", p.queue.size())
+	}
+
+	// items queued without a reservation, e.g. dead letters queued again, are checked on delivery
+	for _, chatId := range []int64{3, 4} {
+		pt, _, _ := p.filterMessage(ctx, 0, textMessage(chatId, "release 1.1"))
+		_, _ = p.queue.push(newQueueItem(route{dest: 100, rule: p.rules[0]}, &post{parts: []part{pt}}), &post{parts: []part{pt}})
+	}
+	drainQueue := p.startQueue(ctx)
+	drainQueue()
+
+	if texts := sent(); len(texts) != 2 || texts[0] != "Release 1.0" || texts[1] != "release 1.1" {
+		t.Fatalf("sent: %q", texts)
+	}
+	if p.queue.size() != 0 {
+		t.Fatalf("left queued: %d", p.queue.size())
+	}
+}
+
+func TestDeadItemReleasesContent(t *testing.T) {
+	p, _ := newTestDelivery(t)
+	p.queue.opts.DeadLetterPath = filepath.Join(t.TempDir(), "dead.jsonl")
+	ctx := context.Background()
+
+	pt, _, _ := p.filterMessage(ctx, 0, textMessage(1, "Release 1.0"))
+	p.handlePost(ctx, &post{parts: []part{pt}})
+	item, _, _ := p.queue.next(time.Now())
+	p.deadItem(item, errors.New("forbidden"))
+
+	pt, _, _ = p.filterMessage(ctx, 0, textMessage(2, "Release 1.0"))
+	p.handlePost(ctx, &post{parts: []part{pt}})
+	if p.queue.size() != 1 {
+		t.Fatalf("content still reserved, queued: %d", p.queue.size())
+	}
+}
+
+func TestRequeueDeadKeepsQueuedItems(t *testing.T) {
+	q := newQueue()
+	q.opts.DeadLetterPath = filepath.Join(t.TempDir(), "dead.jsonl")
+	for _, id := range []string{"a", "b"} {
+		_, _ = q.push(&queueItem{Id: id, NextAt: time.Now()}, nil)
+		item, _, _ := q.next(time.Now())
+		if err := q.dead(item, errors.New("forbidden")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _ = q.push(&queueItem{Id: "a", NextAt: time.Now().Add(time.Hour)}, nil)
+
+	count, err := q.requeueDead()
+	if err != nil || count != 1 {
+		t.Fatalf("requeue: %d, %v", count, err)
+	}
+	raw, _ := ioutil.ReadFile(q.opts.DeadLetterPath)
+	kept := &queueItem{}
+	if err = json.Unmarshal(raw, kept); err != nil || kept.Id != "a" || kept.Error != "forbidden" {
+		t.Fatalf("dead letters: %s", raw)
+	}
+}
+
+func TestDeliverRetriesNonApiErrors(t *testing.T) {
+	p, sent := newTestDelivery(t)
+	p.queue.opts.DeadLetterPath = filepath.Join(t.TempDir(), "dead.jsonl")
+	r, err := compileRule(RuleConfig{Name: "broken", Template: `{{if .ChatId}}{{index .Groups 3}}{{end}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.destinations = []int64{100}
+	p.rules = []*rule{r}
+	ctx := context.Background()
+
+	pt, _, _ := p.filterMessage(ctx, 0, textMessage(1, "Release 1.0"))
+	pt.in.HasMetadata = true
+	p.handlePost(ctx, &post{parts: []part{pt}})
+	item, pst, _ := p.queue.next(time.Now())
+	p.deliverItem(ctx, item, pst)
+
+	if p.queue.size() != 1 || len(sent()) != 0 {
+		t.Fatalf("queued: %d, sent: %q", p.queue.size(), sent())
+	}
+	if _, _, wait := p.queue.next(time.Now()); wait <= 0 {
+		t.Fatal("item is due again")
+	}
+	if _, err = os.Stat(p.queue.opts.DeadLetterPath); !os.IsNotExist(err) {
+		t.Fatalf("item dead-lettered: %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package app
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"strconv"
+	"text/template"
+	"tg-reposter/internal/filter"
+	"tg-reposter/pkg/tgbot"
+	"tg-reposter/pkg/tgclient"
+)
+
+const chatsPageSize = 100
+
+// highlightEntities maps highlight styles to the entities wrapping regex matches.
+var highlightEntities = map[string]string{
+	"bold":      tgbot.EntityBold,
+	"underline": tgbot.EntityUnderline,
+}
+
+type rule struct {
+	name         string
+	conf         RuleConfig
+	re           *regexp.Regexp
+	filter       *filter.Filter
+	mode         DeliveryMode
+	format       TextFormat
+	onDelete     DeletionMode
+	template     *template.Template
+	highlight    string
+	sources      map[int64]bool
+	destinations []int64
+	// runtime rules are added with bot commands and persisted in the store
+	runtime bool
+	// topic rules deliver to subscribed chats instead of destinations
+	topic       bool
+	description string
+}
+
+func compileRules(confs []RuleConfig) ([]*rule, error) {
+	names := map[string]bool{}
+	rules := make([]*rule, 0, len(confs))
+	for i, conf := range confs {
+		if conf.Name == "" {
+			conf.Name = "rule#" + strconv.Itoa(i+1)
+		}
+		if names[conf.Name] {
+			return nil, ValidationErr.New("duplicate rule name: %s", conf.Name)
+		}
+		names[conf.Name] = true
+
+		r, err := compileRule(conf)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func compileTopics(confs []TopicConfig) ([]*rule, error) {
+	names := map[string]bool{}
+	topics := make([]*rule, 0, len(confs))
+	for _, conf := range confs {
+		if conf.Name == "" {
+			return nil, ValidationErr.New("empty topic name")
+		}
+		if names[conf.Name] {
+			return nil, ValidationErr.New("duplicate topic name: %s", conf.Name)
+		}
+		names[conf.Name] = true
+		if len(conf.Destinations) > 0 {
+			return nil, ValidationErr.New("topic must not have destinations. topic: %s", conf.Name)
+		}
+		r, err := compileRule(conf.RuleConfig)
+		if err != nil {
+			return nil, err
+		}
+		r.topic = true
+		r.description = conf.Description
+		topics = append(topics, r)
+	}
+	return topics, nil
+}
+
+func compileRule(conf RuleConfig) (*rule, error) {
+	name := conf.Name
+	if name == "" {
+		return nil, ValidationErr.New("empty rule name")
+	}
+	re, err := regexp.Compile(conf.FilterRegex)
+	if err != nil {
+		return nil, ValidationErr.Wrap(err, "invalid filter regex. rule: %s", name)
+	}
+	f, err := filter.Parse(conf.Filter)
+	if err != nil {
+		return nil, ValidationErr.Wrap(err, "invalid filter. rule: %s", name)
+	}
+	mode := conf.Mode
+	if mode == "" {
+		mode = DeliveryCopy
+	}
+	err = mode.validate()
+	if err != nil {
+		return nil, ValidationErr.Wrap(err, "rule: %s", name)
+	}
+	textFormat := conf.Format
+	if textFormat == "" && conf.Template != "" {
+		textFormat = FormatHTML
+	} else if textFormat == "" {
+		textFormat = FormatEntities
+	}
+	err = textFormat.validate()
+	if err != nil {
+		return nil, ValidationErr.Wrap(err, "rule: %s", name)
+	}
+	onDelete := conf.OnDelete
+	if onDelete == "" {
+		onDelete = DeleteCopy
+	}
+	err = onDelete.validate()
+	if err != nil {
+		return nil, ValidationErr.Wrap(err, "rule: %s", name)
+	}
+	var tmpl *template.Template
+	if conf.Template != "" {
+		if mode == DeliveryForward {
+			return nil, ValidationErr.New("template is not used by forward mode. rule: %s", name)
+		}
+		tmpl, err = compileTemplate(conf, textFormat, re)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var highlight string
+	if conf.Highlight != "" {
+		var ok bool
+		highlight, ok = highlightEntities[conf.Highlight]
+		if !ok {
+			return nil, ValidationErr.New("unknown highlight: %s. rule: %s", conf.Highlight, name)
+		}
+		if mode == DeliveryForward {
+			return nil, ValidationErr.New("highlight is not used by forward mode. rule: %s", name)
+		}
+		if textFormat == FormatPlain {
+			return nil, ValidationErr.New("highlight is not shown by plain format. rule: %s", name)
+		}
+		// templates of the entities format render plain text
+		if tmpl != nil && textFormat == FormatEntities {
+			return nil, ValidationErr.New("highlight of a template needs html or markdown format. rule: %s", name)
+		}
+	}
+	return &rule{
+		name:      name,
+		conf:      conf,
+		re:        re,
+		filter:    f,
+		mode:      mode,
+		format:    textFormat,
+		onDelete:  onDelete,
+		template:  tmpl,
+		highlight: highlight,
+	}, nil
+}
+
+func (r *rule) matchChat(chatId int64) bool {
+	return r.sources == nil || r.sources[chatId]
+}
+
+// usesMetadata reports whether copies of the rule show the source chat or sender.
+func (r *rule) usesMetadata() bool {
+	return r.mode != DeliveryForward && (r.template != nil || r.mode == DeliveryQuote || r.conf.Buttons)
+}
+
+// chatResolver resolves chat references of rules: sources through TDLib, destinations through Bot API.
+type chatResolver struct {
+	client *tgclient.Client
+	bot    *tgbot.Bot
+	titles map[string][]int64
+}
+
+func newChatResolver(client *tgclient.Client, bot *tgbot.Bot) *chatResolver {
+	return &chatResolver{
+		client: client,
+		bot:    bot,
+	}
+}
+
+func (res *chatResolver) resolveRule(ctx context.Context, r *rule, ownerId int64) error {
+	if len(r.conf.Sources) > 0 {
+		r.sources = map[int64]bool{}
+	}
+	for _, ref := range r.conf.Sources {
+		ids, err := res.resolveSource(ctx, ref)
+		if err != nil {
+			return ValidationErr.Wrap(err, "source resolve failed. rule: %s, chat: %s", r.name, ref)
+		}
+		for _, id := range ids {
+			r.sources[id] = true
+		}
+	}
+
+	r.destinations = nil
+	for _, ref := range r.conf.Destinations {
+		id, err := res.resolveDestination(ctx, ref)
+		if err != nil {
+			return ValidationErr.Wrap(err, "destination resolve failed. rule: %s, chat: %s", r.name, ref)
+		}
+		r.destinations = append(r.destinations, id)
+	}
+	if len(r.destinations) == 0 && !r.topic {
+		r.destinations = []int64{ownerId}
+	}
+	return nil
+}
+
+func (res *chatResolver) resolveSource(ctx context.Context, ref ChatRef) ([]int64, error) {
+	if ref.Username != "" {
+		chat, err := res.client.SearchPublicChatContext(ctx, ref.Username)
+		if err != nil {
+			return nil, err
+		}
+		return []int64{chat.Id}, nil
+	}
+	if ref.Title != "" {
+		err := res.loadTitles(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ids, ok := res.titles[ref.Title]
+		if !ok {
+			return nil, ValidationErr.New("chat not found by title")
+		}
+		return ids, nil
+	}
+	return []int64{ref.Id}, nil
+}
+
+func (res *chatResolver) resolveDestination(ctx context.Context, ref ChatRef) (int64, error) {
+	if ref.Title != "" {
+		return 0, ValidationErr.New("destination must be a chat id or @username")
+	}
+	if ref.Username != "" {
+		chat, err := res.bot.GetChatContext(ctx, "@"+ref.Username)
+		if err != nil {
+			return 0, err
+		}
+		return chat.Id, nil
+	}
+	return ref.Id, nil
+}
+
+func (res *chatResolver) loadTitles(ctx context.Context) error {
+	if res.titles != nil {
+		return nil
+	}
+	titles := map[string][]int64{}
+
+	var offsetOrder int64 = math.MaxInt64
+	var offsetChatId int64 = 0
+	for {
+		ids, err := res.client.GetChatsContext(ctx, offsetOrder, offsetChatId, chatsPageSize)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		for _, id := range ids {
+			chat, err := res.client.GetChatContext(ctx, id)
+			if err != nil {
+				return err
+			}
+			titles[chat.Title] = append(titles[chat.Title], chat.Id)
+			offsetOrder = chat.Order
+			offsetChatId = chat.Id
+		}
+		if offsetOrder == 0 {
+			break
+		}
+	}
+
+	res.titles = titles
+	return nil
+}
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"tg-reposter/internal/filter"
+	"tg-reposter/pkg/tgclient"
+)
+
+const (
+	runtimeRulesBucket  = "runtime_rules"
+	pipelineStateBucket = "pipeline_state"
+	pausedKey           = "paused"
+)
+
+// currentRules returns a snapshot of the rules, it is safe to use while rules are changed.
+func (p *Pipeline) currentRules() []*rule {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]*rule(nil), p.rules...)
+}
+
+func (p *Pipeline) isPaused() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.paused
+}
+
+// setPaused stops or resumes reposting, the state survives restarts.
+func (p *Pipeline) setPaused(paused bool) error {
+	p.mu.Lock()
+	p.paused = paused
+	p.mu.Unlock()
+
+	if p.store == nil {
+		return nil
+	}
+	if !paused {
+		return p.store.Delete(pipelineStateBucket, pausedKey)
+	}
+	return p.store.Put(pipelineStateBucket, pausedKey, nil)
+}
+
+// addRule compiles and resolves a rule and adds it to the running pipeline, persisting it if asked.
+func (p *Pipeline) addRule(ctx context.Context, conf RuleConfig, persist bool) error {
+	r, err := compileRule(conf)
+	if err != nil {
+		return err
+	}
+	p.mu.RLock()
+	resolver, ownerId := p.resolver, p.ownerId
+	p.mu.RUnlock()
+	if resolver == nil {
+		return ValidationErr.New("pipeline is not started")
+	}
+	err = resolver.resolveRule(ctx, r, ownerId)
+	if err != nil {
+		return err
+	}
+	r.runtime = true
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, existing := range p.rules {
+		if existing.name == r.name {
+			return ValidationErr.New("duplicate rule name: %s", r.name)
+		}
+	}
+	if persist && p.store != nil {
+		err = p.store.PutJSON(runtimeRulesBucket, r.name, r.conf)
+		if err != nil {
+			return err
+		}
+	}
+	p.rules = append(p.rules, r)
+	p.logger.Infof("rule %s: sources: %v, destinations: %v", r.name, r.conf.Sources, r.destinations)
+	return nil
+}
+
+// removeRule removes a rule added at runtime. Rules of the config file are changed in the file.
+func (p *Pipeline) removeRule(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, r := range p.rules {
+		if r.name != name {
+			continue
+		}
+		if !r.runtime {
+			return ValidationErr.New("rule %s is defined in the config file", name)
+		}
+		if p.store != nil {
+			err := p.store.Delete(runtimeRulesBucket, name)
+			if err != nil {
+				return err
+			}
+		}
+		p.rules = append(p.rules[:i:i], p.rules[i+1:]...)
+		p.logger.Infof("rule %s removed", name)
+		return nil
+	}
+	return ValidationErr.New("rule not found: %s", name)
+}
+
+// testText returns names of rules whose filters match a text message, source chats are not checked.
+func (p *Pipeline) testText(text string) []string {
+	in := &filter.Input{
+		ContentType: tgclient.MessageTextType,
+		Text:        text,
+	}
+	var names []string
+	for _, r := range p.currentRules() {
+		if r.re.MatchString(in.Text) && r.filter.Match(in) {
+			names = append(names, r.name)
+		}
+	}
+	return names
+}
+
+// loadState restores the paused state and runtime rules. Rules failed to load are skipped.
+func (p *Pipeline) loadState(ctx context.Context) {
+	if p.store == nil {
+		return
+	}
+	if _, ok := p.store.Get(pipelineStateBucket, pausedKey); ok {
+		p.mu.Lock()
+		p.paused = true
+		p.mu.Unlock()
+		p.logger.Warn("pipeline is paused, send /resume to the bot to continue")
+	}
+	for _, name := range p.store.Keys(runtimeRulesBucket) {
+		conf := RuleConfig{}
+		_, err := p.store.GetJSON(runtimeRulesBucket, name, &conf)
+		if err == nil {
+			err = p.addRule(ctx, conf, false)
+		}
+		if err != nil {
+			p.logger.Errorf("runtime rule load failed. rule: %s. %+v", name, err)
+		}
+	}
+}
+
+func (p *Pipeline) findTopic(name string) *rule {
+	for _, r := range p.currentRules() {
+		if r.topic && r.name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// subscribe delivers messages of the topic to the chat.
+func (p *Pipeline) subscribe(topic string, chatId int64) error {
+	if p.findTopic(topic) == nil {
+		return ValidationErr.New("topic not found: %s", topic)
+	}
+	err := p.destinations.enable(chatId)
+	if err != nil {
+		return err
+	}
+	return p.subs.subscribe(topic, chatId)
+}
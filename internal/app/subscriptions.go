@@ -0,0 +1,132 @@
+package app
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"tg-reposter/pkg/kvstore"
+)
+
+const subscriptionsBucket = "subscriptions"
+
+// subscriptions maps topics to subscribed chats, they survive restarts when a store is set.
+type subscriptions struct {
+	store  *kvstore.Store
+	mu     sync.RWMutex
+	topics map[string]map[int64]bool
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{topics: map[string]map[int64]bool{}}
+}
+
+func (s *subscriptions) load(store *kvstore.Store) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+	for _, key := range store.Keys(subscriptionsBucket) {
+		chatId, topic, err := parseSubscriptionKey(key)
+		if err != nil {
+			return err
+		}
+		s.add(topic, chatId)
+	}
+	return nil
+}
+
+func (s *subscriptions) subscribe(topic string, chatId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store != nil {
+		err := s.store.Put(subscriptionsBucket, subscriptionKey(chatId, topic), nil)
+		if err != nil {
+			return err
+		}
+	}
+	s.add(topic, chatId)
+	return nil
+}
+
+// unsubscribe removes a subscription and reports whether it existed.
+func (s *subscriptions) unsubscribe(topic string, chatId int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.topics[topic][chatId] {
+		return false, nil
+	}
+	if s.store != nil {
+		err := s.store.Delete(subscriptionsBucket, subscriptionKey(chatId, topic))
+		if err != nil {
+			return false, err
+		}
+	}
+	delete(s.topics[topic], chatId)
+	return true, nil
+}
+
+// unsubscribeAll removes all subscriptions of a chat and returns their topics.
+func (s *subscriptions) unsubscribeAll(chatId int64) ([]string, error) {
+	var removed []string
+	for _, topic := range s.topicsOf(chatId) {
+		ok, err := s.unsubscribe(topic, chatId)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed = append(removed, topic)
+		}
+	}
+	return removed, nil
+}
+
+func (s *subscriptions) subscribers(topic string) []int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]int64, 0, len(s.topics[topic]))
+	for id := range s.topics[topic] {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func (s *subscriptions) topicsOf(chatId int64) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var topics []string
+	for topic, chats := range s.topics {
+		if chats[chatId] {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (s *subscriptions) add(topic string, chatId int64) {
+	chats, ok := s.topics[topic]
+	if !ok {
+		chats = map[int64]bool{}
+		s.topics[topic] = chats
+	}
+	chats[chatId] = true
+}
+
+func subscriptionKey(chatId int64, topic string) string {
+	return strconv.FormatInt(chatId, 10) + ":" + topic
+}
+
+func parseSubscriptionKey(key string) (int64, string, error) {
+	sep := strings.IndexByte(key, ':')
+	if sep < 0 {
+		return 0, "", ValidationErr.New("invalid subscription: %s", key)
+	}
+	chatId, err := strconv.ParseInt(key[:sep], 10, 64)
+	if err != nil {
+		return 0, "", ValidationErr.Wrap(err, "invalid subscription: %s", key)
+	}
+	return chatId, key[sep+1:], nil
+}
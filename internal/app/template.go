@@ -0,0 +1,127 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"regexp"
+	"text/template"
+	"tg-reposter/internal/format"
+	"tg-reposter/pkg/tgbot"
+	"time"
+	"unicode/utf8"
+)
+
+// templateData is the data of rule templates. Text is the message text rendered in the rule format,
+// other fields are raw text and are escaped with the html, markdown or escape functions.
+// Groups are the FilterRegex match followed by its capture groups, Named are the named capture groups.
+type templateData struct {
+	Text           string
+	ChatId         int64
+	ChatTitle      string
+	ChatUsername   string
+	SenderId       int32
+	SenderName     string
+	SenderUsername string
+	Link           string
+	Date           time.Time
+	Rule           string
+	Groups         []string
+	Named          map[string]string
+}
+
+// compileTemplate parses the rule template and executes it once on empty data,
+// so unknown fields and functions fail on start instead of on delivery.
+func compileTemplate(conf RuleConfig, textFormat TextFormat, re *regexp.Regexp) (*template.Template, error) {
+	tmpl, err := template.New(conf.Name).
+		Option("missingkey=error").
+		Funcs(templateFuncs(textFormat)).
+		Parse(conf.Template)
+	if err != nil {
+		return nil, ValidationErr.Wrap(err, "invalid template. rule: %s", conf.Name)
+	}
+	data := templateData{
+		Groups: make([]string, re.NumSubexp()+1),
+		Named:  namedGroups(re, nil),
+	}
+	err = tmpl.Execute(ioutil.Discard, data)
+	if err != nil {
+		return nil, ValidationErr.Wrap(err, "invalid template. rule: %s", conf.Name)
+	}
+	return tmpl, nil
+}
+
+func templateFuncs(textFormat TextFormat) template.FuncMap {
+	return template.FuncMap{
+		"html":     format.EscapeHTML,
+		"markdown": format.EscapeMarkdownV2,
+		"escape":   textFormat.escape,
+	}
+}
+
+// renderTemplate renders the part with the rule template. Templates of the entities
+// and plain formats produce plain text.
+func (r *rule) renderTemplate(pt part) (rendered, error) {
+	in := pt.in
+	data := templateData{
+		ChatId:         in.Message.ChatId,
+		ChatTitle:      in.Chat.Title,
+		ChatUsername:   in.ChatUsername,
+		SenderId:       in.Message.SenderUserId,
+		SenderName:     senderName(in.Sender),
+		SenderUsername: in.Sender.UserName,
+		Link:           messageLink(in.Chat, in.ChatUsername, in.Message.ServerId()),
+		Date:           time.Unix(int64(in.Message.Date), 0),
+		Rule:           r.name,
+	}
+	data.Groups = r.re.FindStringSubmatch(in.Text)
+	data.Named = namedGroups(r.re, data.Groups)
+	switch r.format {
+	case FormatHTML, FormatMarkdown:
+		data.Text = r.format.render(r.messageText(pt)).text
+	default:
+		data.Text = pt.text().Text
+	}
+
+	var b bytes.Buffer
+	err := r.template.Execute(&b, data)
+	if err != nil {
+		return rendered{}, TemplateErr.Wrap(err, "template failed. rule: %s", r.name)
+	}
+	return rendered{text: b.String(), parseMode: r.format.parseMode(), length: utf8.RuneCount(b.Bytes())}, nil
+}
+
+// namedGroups maps names of regex capture groups to their values in groups, empty if the regex did not match.
+func namedGroups(re *regexp.Regexp, groups []string) map[string]string {
+	named := map[string]string{}
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		named[name] = ""
+		if i < len(groups) {
+			named[name] = groups[i]
+		}
+	}
+	return named
+}
+
+func (f TextFormat) parseMode() string {
+	switch f {
+	case FormatHTML:
+		return tgbot.ParseModeHTML
+	case FormatMarkdown:
+		return tgbot.ParseModeMarkdownV2
+	}
+	return ""
+}
+
+// escape escapes a plain text for the format.
+func (f TextFormat) escape(text string) string {
+	switch f {
+	case FormatHTML:
+		return format.EscapeHTML(text)
+	case FormatMarkdown:
+		return format.EscapeMarkdownV2(text)
+	}
+	return text
+}
@@ -0,0 +1,22 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/joomcode/errorx"
+	"strings"
+	"unicode/utf8"
+)
+
+var Errors = errorx.NewNamespace("filter")
+var SyntaxErr = Errors.NewType("syntax")
+
+// ColumnProperty holds the 1-based column of a syntax error.
+var ColumnProperty = errorx.RegisterProperty("column")
+
+func newSyntaxError(src string, pos int, format string, args ...interface{}) error {
+	col := utf8.RuneCountInString(src[:pos]) + 1
+	msg := fmt.Sprintf(format, args...)
+	caret := strings.Repeat(" ", col-1) + "^"
+	return SyntaxErr.New("column %d: %s\n\t%s\n\t%s", col, msg, src, caret).
+		WithProperty(ColumnProperty, col)
+}
@@ -0,0 +1,270 @@
+// Package filter implements the message filter expression language.
+//
+// An expression combines predicates with and/or/not (&&, ||, !) and parentheses:
+//
+//	keywords("release", "релиз") and not forwarded and length > 20
+//	(chat(-1001234567890) or sender(@alice, 42)) and (has_link or has_media)
+//
+// Predicates:
+//
+//	regex("expr"), iregex("expr")   text matches regex, iregex ignores case
+//	contains("a", ...)              text contains any of substrings
+//	icontains("a", ...)             same, ignoring case
+//	keywords("a", ...)              text contains any of whole words, ignoring case
+//	sender(id, @username, ...)      message is sent by any of users
+//	chat(id, @username, ...)        message is posted in any of chats
+//	type(text, photo, ...)          message content type
+//	has_link, has_media, forwarded  message properties
+//	length <op> n                   text length in characters, op is one of < <= > >= == !=
+package filter
+
+import (
+	"regexp"
+	"strings"
+	"tg-reposter/pkg/tgclient"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Input is a message with metadata a filter is evaluated against.
+// The chat and sender metadata are loaded separately and only if needed, see UsesMetadata.
+type Input struct {
+	Message     tgclient.Message
+	ContentType tgclient.ClassType
+	// Text is a message text or a media caption
+	Text         string
+	Chat         tgclient.Chat
+	ChatUsername string
+	Sender       tgclient.User
+	// HasMetadata reports whether Chat, ChatUsername and Sender are loaded
+	HasMetadata bool
+}
+
+// Filter is a compiled filter expression.
+type Filter struct {
+	src  string
+	root node
+}
+
+// Parse compiles an expression. An empty expression matches any message.
+func Parse(src string) (*Filter, error) {
+	if strings.TrimSpace(src) == "" {
+		return &Filter{src: src, root: trueNode{}}, nil
+	}
+	tokens, err := (&lexer{src: src}).tokens()
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, tokens: tokens}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s", describe(tok))
+	}
+	return &Filter{src: src, root: root}, nil
+}
+
+// Regex builds a filter matching text against a regex.
+func Regex(re *regexp.Regexp) *Filter {
+	return &Filter{src: "regex(" + re.String() + ")", root: regexNode{re}}
+}
+
+func (f *Filter) Match(in *Input) bool {
+	return f.root.eval(in)
+}
+
+// UsesMetadata reports whether the filter checks chat or sender usernames, the metadata must be loaded before Match.
+func (f *Filter) UsesMetadata() bool {
+	return usesMetadata(f.root)
+}
+
+func usesMetadata(n node) bool {
+	switch n := n.(type) {
+	case andNode:
+		return usesMetadata(n.left) || usesMetadata(n.right)
+	case orNode:
+		return usesMetadata(n.left) || usesMetadata(n.right)
+	case notNode:
+		return usesMetadata(n.n)
+	case senderNode:
+		return len(n.usernames) > 0
+	case chatNode:
+		return len(n.usernames) > 0
+	}
+	return false
+}
+
+func (f *Filter) String() string {
+	return f.src
+}
+
+var contentTypes = map[string]tgclient.ClassType{
+	"text":       tgclient.MessageTextType,
+	"photo":      tgclient.MessagePhotoType,
+	"video":      tgclient.MessageVideoType,
+	"document":   tgclient.MessageDocumentType,
+	"animation":  tgclient.MessageAnimationType,
+	"voice":      tgclient.MessageVoiceNoteType,
+	"audio":      tgclient.MessageAudioType,
+	"sticker":    tgclient.MessageStickerType,
+	"video_note": tgclient.MessageVideoNoteType,
+}
+
+var mediaTypes = map[tgclient.ClassType]bool{
+	tgclient.MessagePhotoType:     true,
+	tgclient.MessageVideoType:     true,
+	tgclient.MessageDocumentType:  true,
+	tgclient.MessageAnimationType: true,
+	tgclient.MessageVoiceNoteType: true,
+	tgclient.MessageAudioType:     true,
+	tgclient.MessageStickerType:   true,
+	tgclient.MessageVideoNoteType: true,
+}
+
+var linkRe = regexp.MustCompile(`(?i)(https?://|www\.|\bt\.me/)\S+`)
+
+type node interface {
+	eval(in *Input) bool
+}
+
+type trueNode struct{}
+
+func (trueNode) eval(*Input) bool { return true }
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(in *Input) bool { return n.left.eval(in) && n.right.eval(in) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(in *Input) bool { return n.left.eval(in) || n.right.eval(in) }
+
+type notNode struct{ n node }
+
+func (n notNode) eval(in *Input) bool { return !n.n.eval(in) }
+
+type regexNode struct{ re *regexp.Regexp }
+
+func (n regexNode) eval(in *Input) bool { return n.re.MatchString(in.Text) }
+
+type containsNode struct {
+	words []string
+	fold  bool
+}
+
+func (n containsNode) eval(in *Input) bool {
+	text := in.Text
+	if n.fold {
+		text = strings.ToLower(text)
+	}
+	for _, w := range n.words {
+		if strings.Contains(text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+type keywordsNode struct{ words []string }
+
+func newKeywordsNode(words []string) keywordsNode {
+	return keywordsNode{lowerAll(words)}
+}
+
+func (n keywordsNode) eval(in *Input) bool {
+	text := strings.ToLower(in.Text)
+	for _, w := range n.words {
+		if containsWord(text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsWord reports whether word occurs in text not surrounded by letters or digits.
+func containsWord(text, word string) bool {
+	if word == "" {
+		return false
+	}
+	offset := 0
+	for {
+		i := strings.Index(text[offset:], word)
+		if i < 0 {
+			return false
+		}
+		start := offset + i
+		end := start + len(word)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !isWordRune(before) && !isWordRune(after) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+type senderNode struct {
+	ids       map[int64]bool
+	usernames map[string]bool
+}
+
+func (n senderNode) eval(in *Input) bool {
+	return n.ids[int64(in.Message.SenderUserId)] ||
+		(in.Sender.UserName != "" && n.usernames[strings.ToLower(in.Sender.UserName)])
+}
+
+type chatNode struct {
+	ids       map[int64]bool
+	usernames map[string]bool
+}
+
+func (n chatNode) eval(in *Input) bool {
+	return n.ids[in.Message.ChatId] ||
+		(in.ChatUsername != "" && n.usernames[strings.ToLower(in.ChatUsername)])
+}
+
+type typeNode struct{ types map[tgclient.ClassType]bool }
+
+func (n typeNode) eval(in *Input) bool { return n.types[in.ContentType] }
+
+type hasLinkNode struct{}
+
+func (hasLinkNode) eval(in *Input) bool { return linkRe.MatchString(in.Text) }
+
+type hasMediaNode struct{}
+
+func (hasMediaNode) eval(in *Input) bool { return mediaTypes[in.ContentType] }
+
+type forwardedNode struct{}
+
+func (forwardedNode) eval(in *Input) bool { return in.Message.IsForwarded() }
+
+type lengthNode struct {
+	op string
+	n  int
+}
+
+func (n lengthNode) eval(in *Input) bool {
+	l := utf8.RuneCountInString(in.Text)
+	switch n.op {
+	case "<":
+		return l < n.n
+	case "<=":
+		return l <= n.n
+	case ">":
+		return l > n.n
+	case ">=":
+		return l >= n.n
+	case "==":
+		return l == n.n
+	case "!=":
+		return l != n.n
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package filter
+
+import (
+	"encoding/json"
+	"github.com/joomcode/errorx"
+	"testing"
+	"tg-reposter/pkg/tgclient"
+	"time"
+)
+
+func TestUsesMetadata(t *testing.T) {
+	cases := map[string]bool{
+		``:                                 false,
+		`keywords("go") and chat(-100)`:    false,
+		`sender(42) or not forwarded`:      false,
+		`keywords("go") and chat(@news)`:   true,
+		`has_link or not sender(@alice)`:   true,
+		`(type(text) and sender(1, @bob))`: true,
+	}
+	for src, want := range cases {
+		f, err := Parse(src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if got := f.UsesMetadata(); got != want {
+			t.Errorf("%s: got %v, want %v", src, got, want)
+		}
+	}
+}
+
+func TestLexer(t *testing.T) {
+	cases := []struct {
+		src   string
+		kinds []tokenKind
+		texts []string
+	}{
+		{`length >= -12`, []tokenKind{tokIdent, tokCmp, tokNumber, tokEOF}, []string{"length", ">=", "-12", ""}},
+		{`a&&!b||c`, []tokenKind{tokIdent, tokAnd, tokNot, tokIdent, tokOr, tokIdent, tokEOF}, []string{"a", "&&", "!", "b", "||", "c", ""}},
+		{`chat(@news, "a\"b", ` + "`x\\y`" + `)`, []tokenKind{tokIdent, tokLParen, tokIdent, tokComma, tokString, tokComma, tokString, tokRParen, tokEOF},
+			[]string{"chat", "(", "@news", ",", `a"b`, ",", `x\y`, ")", ""}},
+		{`релиз AND not`, []tokenKind{tokIdent, tokAnd, tokNot, tokEOF}, []string{"релиз", "AND", "not", ""}},
+	}
+	for _, c := range cases {
+		tokens, err := (&lexer{src: c.src}).tokens()
+		if err != nil {
+			t.Fatalf("%s: %v", c.src, err)
+		}
+		if len(tokens) != len(c.kinds) {
+			t.Fatalf("%s: tokens %+v", c.src, tokens)
+		}
+		for i, tok := range tokens {
+			if tok.kind != c.kinds[i] || tok.text != c.texts[i] {
+				t.Errorf("%s: token %d is %s %q, want %s %q", c.src, i, tok.kind, tok.text, c.kinds[i], c.texts[i])
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		src    string
+		column int
+	}{
+		// Arabic-Indic digit three, once looped the lexer forever
+		{`length > ٣`, 10},
+		{`length > -`, 10},
+		{`has_link and`, 13},
+		{`(has_link`, 10},
+		{`has_link has_media`, 10},
+		{`keywords()`, 1},
+		{`regex("(")`, 7},
+		{`contains("a"`, 13},
+		{`unknown(1)`, 1},
+		{`type(text, foo)`, 12},
+		{`"unterminated`, 1},
+		{`length > 1 # x`, 12},
+		{`chat(-100) and релиз`, 16},
+	}
+	for _, c := range cases {
+		var err error
+		withTimeout(t, func() {
+			_, err = Parse(c.src)
+		})
+		if !errorx.IsOfType(err, SyntaxErr) {
+			t.Errorf("%s: %v", c.src, err)
+			continue
+		}
+		col, _ := errorx.ExtractProperty(err, ColumnProperty)
+		if col != c.column {
+			t.Errorf("%s: column %v, want %d. %v", c.src, col, c.column, err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	in := &Input{
+		Message: tgclient.Message{
+			ChatId:       -100,
+			SenderUserId: 42,
+			ForwardInfo:  json.RawMessage(`{"@type":"messageForwardInfo"}`),
+		},
+		ContentType:  tgclient.MessagePhotoType,
+		Text:         "Go 1.13 релиз: https://golang.org",
+		ChatUsername: "GoNews",
+		Sender:       tgclient.User{UserName: "alice"},
+	}
+	cases := map[string]bool{
+		``:                                         true,
+		`keywords("РЕЛИЗ")`:                        true,
+		`keywords("рел")`:                          false,
+		`contains("Go") and icontains("GOLANG")`:   true,
+		`regex("^go")`:                             false,
+		`iregex("^go")`:                            true,
+		`chat(@gonews) and sender(42)`:             true,
+		`chat(-200) or sender(@bob)`:               false,
+		`type(photo) and has_media and has_link`:   true,
+		`forwarded && length > 30 && length <= 33`: true,
+		`length == 33`:                             true,
+		// and binds tighter than or, not tighter than and
+		`has_link or has_media and type(text)`:        true,
+		`(has_link or has_media) and type(text)`:      false,
+		`not forwarded or keywords("go")`:             true,
+		`not (forwarded or keywords("go"))`:           false,
+		`!forwarded && !type(text) || keywords("no")`: false,
+	}
+	for src, want := range cases {
+		f, err := Parse(src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if got := f.Match(in); got != want {
+			t.Errorf("%s: got %v, want %v", src, got, want)
+		}
+	}
+}
+
+// withTimeout fails the test if fn does not return in time, e.g. on an endless loop.
+func withTimeout(t *testing.T, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out")
+	}
+}
@@ -0,0 +1,192 @@
+package filter
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokLParen
+	tokRParen
+	tokComma
+	tokCmp
+	tokAnd
+	tokOr
+	tokNot
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokEOF:
+		return "end of expression"
+	case tokIdent:
+		return "identifier"
+	case tokString:
+		return "string"
+	case tokNumber:
+		return "number"
+	case tokLParen:
+		return "'('"
+	case tokRParen:
+		return "')'"
+	case tokComma:
+		return "','"
+	case tokCmp:
+		return "comparison"
+	case tokAnd:
+		return "'and'"
+	case tokOr:
+		return "'or'"
+	case tokNot:
+		return "'not'"
+	}
+	return "unknown"
+}
+
+type token struct {
+	kind tokenKind
+	// text is the raw token, for strings it is the unquoted value
+	text string
+	pos  int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) tokens() ([]token, error) {
+	var res []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, tok)
+		if tok.kind == tokEOF {
+			return res, nil
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		l.pos += size
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	rest := l.src[l.pos:]
+	r, size := utf8.DecodeRuneInString(rest)
+
+	switch {
+	case r == '(':
+		l.pos++
+		return token{kind: tokLParen, text: "(", pos: start}, nil
+	case r == ')':
+		l.pos++
+		return token{kind: tokRParen, text: ")", pos: start}, nil
+	case r == ',':
+		l.pos++
+		return token{kind: tokComma, text: ",", pos: start}, nil
+	case strings.HasPrefix(rest, "&&"):
+		l.pos += 2
+		return token{kind: tokAnd, text: "&&", pos: start}, nil
+	case strings.HasPrefix(rest, "||"):
+		l.pos += 2
+		return token{kind: tokOr, text: "||", pos: start}, nil
+	case strings.HasPrefix(rest, "<="), strings.HasPrefix(rest, ">="),
+		strings.HasPrefix(rest, "=="), strings.HasPrefix(rest, "!="):
+		l.pos += 2
+		return token{kind: tokCmp, text: rest[:2], pos: start}, nil
+	case r == '<' || r == '>':
+		l.pos++
+		return token{kind: tokCmp, text: rest[:1], pos: start}, nil
+	case r == '!':
+		l.pos++
+		return token{kind: tokNot, text: "!", pos: start}, nil
+	case r == '"' || r == '`':
+		return l.lexString(r)
+	case r == '-' || isDigit(r):
+		return l.lexNumber()
+	case r == '@' || r == '_' || unicode.IsLetter(r):
+		l.pos += size
+		for l.pos < len(l.src) {
+			r, size = utf8.DecodeRuneInString(l.src[l.pos:])
+			if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			l.pos += size
+		}
+		text := l.src[start:l.pos]
+		switch strings.ToLower(text) {
+		case "and":
+			return token{kind: tokAnd, text: text, pos: start}, nil
+		case "or":
+			return token{kind: tokOr, text: text, pos: start}, nil
+		case "not":
+			return token{kind: tokNot, text: text, pos: start}, nil
+		}
+		return token{kind: tokIdent, text: text, pos: start}, nil
+	}
+	return token{}, newSyntaxError(l.src, start, "unexpected character %q", r)
+}
+
+func (l *lexer) lexString(quote rune) (token, error) {
+	start := l.pos
+	l.pos++
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == '\\' && quote == '"' {
+			l.pos += 2
+			continue
+		}
+		l.pos++
+		if rune(c) == quote {
+			raw := l.src[start:l.pos]
+			val, err := strconv.Unquote(raw)
+			if err != nil {
+				return token{}, newSyntaxError(l.src, start, "invalid string %s", raw)
+			}
+			return token{kind: tokString, text: val, pos: start}, nil
+		}
+	}
+	return token{}, newSyntaxError(l.src, start, "unterminated string")
+}
+
+func (l *lexer) lexNumber() (token, error) {
+	start := l.pos
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	for l.pos < len(l.src) && isDigit(rune(l.src[l.pos])) {
+		l.pos++
+	}
+	text := l.src[start:l.pos]
+	if text == "-" {
+		return token{}, newSyntaxError(l.src, start, "expected number after '-'")
+	}
+	if text == "" {
+		return token{}, newSyntaxError(l.src, start, "expected number")
+	}
+	return token{kind: tokNumber, text: text, pos: start}, nil
+}
+
+// isDigit reports ASCII digits only, other Unicode digits are not numbers in expressions.
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
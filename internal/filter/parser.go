@@ -0,0 +1,287 @@
+package filter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"tg-reposter/pkg/tgclient"
+)
+
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	tok := p.advance()
+	if tok.kind != kind {
+		return tok, p.errorf(tok, "expected %s, got %s", kind, describe(tok))
+	}
+	return tok, nil
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return newSyntaxError(p.src, tok.pos, format, args...)
+}
+
+func describe(tok token) string {
+	if tok.kind == tokEOF {
+		return tok.kind.String()
+	}
+	if tok.kind == tokString {
+		return strconv.Quote(tok.text)
+	}
+	return "'" + tok.text + "'"
+}
+
+func (p *parser) parseExpr() (node, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.advance()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.peek().kind == tokNot {
+		p.advance()
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.advance()
+	switch tok.kind {
+	case tokLParen:
+		n, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(tokRParen)
+		return n, err
+	case tokIdent:
+		return p.parsePredicate(tok)
+	}
+	return nil, p.errorf(tok, "expected predicate, got %s", describe(tok))
+}
+
+func (p *parser) parsePredicate(name token) (node, error) {
+	ident := strings.ToLower(name.text)
+
+	if ident == "length" {
+		return p.parseLength(name)
+	}
+
+	var args []token
+	if p.peek().kind == tokLParen {
+		p.advance()
+		var err error
+		args, err = p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch ident {
+	case "regex", "iregex":
+		if len(args) != 1 || args[0].kind != tokString {
+			return nil, p.errorf(name, "%s expects a single string argument", ident)
+		}
+		expr := args[0].text
+		if ident == "iregex" {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, p.errorf(args[0], "invalid regex: %s", err)
+		}
+		return regexNode{re}, nil
+	case "contains", "icontains", "keywords":
+		words, err := p.stringArgs(name, args)
+		if err != nil {
+			return nil, err
+		}
+		switch ident {
+		case "contains":
+			return containsNode{words: words}, nil
+		case "icontains":
+			return containsNode{words: lowerAll(words), fold: true}, nil
+		}
+		return newKeywordsNode(words), nil
+	case "sender":
+		ids, usernames, err := p.refArgs(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return senderNode{ids: ids, usernames: usernames}, nil
+	case "chat":
+		ids, usernames, err := p.refArgs(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return chatNode{ids: ids, usernames: usernames}, nil
+	case "type":
+		if len(args) == 0 {
+			return nil, p.errorf(name, "type expects at least one content type")
+		}
+		types := map[tgclient.ClassType]bool{}
+		for _, arg := range args {
+			if arg.kind != tokString && arg.kind != tokIdent {
+				return nil, p.errorf(arg, "expected content type, got %s", describe(arg))
+			}
+			t, ok := contentTypes[strings.ToLower(arg.text)]
+			if !ok {
+				t = tgclient.ClassType(arg.text)
+				if !strings.HasPrefix(arg.text, "message") {
+					return nil, p.errorf(arg, "unknown content type %q", arg.text)
+				}
+			}
+			types[t] = true
+		}
+		return typeNode{types}, nil
+	case "has_link", "has_media", "forwarded":
+		if len(args) > 0 {
+			return nil, p.errorf(args[0], "%s expects no arguments", ident)
+		}
+		switch ident {
+		case "has_link":
+			return hasLinkNode{}, nil
+		case "has_media":
+			return hasMediaNode{}, nil
+		}
+		return forwardedNode{}, nil
+	}
+	return nil, p.errorf(name, "unknown predicate %q", name.text)
+}
+
+func (p *parser) parseArgs() ([]token, error) {
+	var args []token
+	if p.peek().kind == tokRParen {
+		p.advance()
+		return args, nil
+	}
+	for {
+		tok := p.advance()
+		switch tok.kind {
+		case tokString, tokNumber, tokIdent:
+			args = append(args, tok)
+		default:
+			return nil, p.errorf(tok, "expected argument, got %s", describe(tok))
+		}
+		sep := p.advance()
+		if sep.kind == tokRParen {
+			return args, nil
+		}
+		if sep.kind != tokComma {
+			return nil, p.errorf(sep, "expected ',' or ')', got %s", describe(sep))
+		}
+	}
+}
+
+func (p *parser) parseLength(name token) (node, error) {
+	op, err := p.expect(tokCmp)
+	if err != nil {
+		return nil, err
+	}
+	num, err := p.expect(tokNumber)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(num.text)
+	if err != nil || n < 0 {
+		return nil, p.errorf(num, "invalid length %s", num.text)
+	}
+	return lengthNode{op: op.text, n: n}, nil
+}
+
+func (p *parser) stringArgs(name token, args []token) ([]string, error) {
+	if len(args) == 0 {
+		return nil, p.errorf(name, "%s expects at least one string", name.text)
+	}
+	res := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg.kind != tokString {
+			return nil, p.errorf(arg, "expected string, got %s", describe(arg))
+		}
+		res = append(res, arg.text)
+	}
+	return res, nil
+}
+
+func (p *parser) refArgs(name token, args []token) (ids map[int64]bool, usernames map[string]bool, err error) {
+	if len(args) == 0 {
+		return nil, nil, p.errorf(name, "%s expects at least one id or @username", name.text)
+	}
+	ids = map[int64]bool{}
+	usernames = map[string]bool{}
+	for _, arg := range args {
+		val := arg.text
+		if arg.kind == tokNumber || (arg.kind == tokString && !strings.HasPrefix(val, "@")) {
+			id, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, nil, p.errorf(arg, "invalid id %s", describe(arg))
+			}
+			ids[id] = true
+			continue
+		}
+		if !strings.HasPrefix(val, "@") || len(val) == 1 {
+			return nil, nil, p.errorf(arg, "expected id or @username, got %s", describe(arg))
+		}
+		usernames[strings.ToLower(val[1:])] = true
+	}
+	return ids, usernames, nil
+}
+
+func lowerAll(words []string) []string {
+	res := make([]string, len(words))
+	for i, w := range words {
+		res[i] = strings.ToLower(w)
+	}
+	return res
+}
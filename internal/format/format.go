@@ -0,0 +1,238 @@
+// Package format converts TDLib formatted texts into Bot API entities, HTML or MarkdownV2.
+//
+// TDLib and Bot API both measure entity offsets in UTF-16 code units,
+// so the helpers here work on UTF-16 encoded text.
+package format
+
+import (
+	"regexp"
+	"sort"
+	"tg-reposter/pkg/tgbot"
+	"tg-reposter/pkg/tgclient"
+	"unicode/utf16"
+)
+
+var entityTypes = map[tgclient.ClassType]string{
+	tgclient.TextEntityMentionType:       tgbot.EntityMention,
+	tgclient.TextEntityHashtagType:       tgbot.EntityHashtag,
+	tgclient.TextEntityCashtagType:       tgbot.EntityCashtag,
+	tgclient.TextEntityBotCommandType:    tgbot.EntityBotCommand,
+	tgclient.TextEntityUrlType:           tgbot.EntityUrl,
+	tgclient.TextEntityEmailAddressType:  tgbot.EntityEmail,
+	tgclient.TextEntityPhoneNumberType:   tgbot.EntityPhoneNumber,
+	tgclient.TextEntityBoldType:          tgbot.EntityBold,
+	tgclient.TextEntityItalicType:        tgbot.EntityItalic,
+	tgclient.TextEntityUnderlineType:     tgbot.EntityUnderline,
+	tgclient.TextEntityStrikethroughType: tgbot.EntityStrikethrough,
+	tgclient.TextEntityCodeType:          tgbot.EntityCode,
+	tgclient.TextEntityPreType:           tgbot.EntityPre,
+	tgclient.TextEntityPreCodeType:       tgbot.EntityPre,
+	tgclient.TextEntityTextUrlType:       tgbot.EntityTextLink,
+	tgclient.TextEntityMentionNameType:   tgbot.EntityTextMention,
+}
+
+// Entities converts TDLib entities into Bot API ones, unknown types are dropped.
+func Entities(ft tgclient.FormattedText) []tgbot.MessageEntity {
+	res := make([]tgbot.MessageEntity, 0, len(ft.Entities))
+	for _, e := range ft.Entities {
+		t, ok := entityTypes[e.Type.Type]
+		if !ok || e.Length <= 0 {
+			continue
+		}
+		entity := tgbot.MessageEntity{
+			Type:     t,
+			Offset:   int(e.Offset),
+			Length:   int(e.Length),
+			Url:      e.Type.Url,
+			Language: e.Type.Language,
+		}
+		if e.Type.Type == tgclient.TextEntityMentionNameType {
+			entity.User = &tgbot.User{Id: int64(e.Type.UserId)}
+		}
+		res = append(res, entity)
+	}
+	return res
+}
+
+// Len returns the text length in UTF-16 code units.
+func Len(text string) int {
+	n := 0
+	for _, r := range text {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// Shift moves entities by offset UTF-16 code units, e.g. after a prefix is added to a text.
+func Shift(entities []tgbot.MessageEntity, offset int) []tgbot.MessageEntity {
+	res := make([]tgbot.MessageEntity, len(entities))
+	for i, e := range entities {
+		e.Offset += offset
+		res[i] = e
+	}
+	return res
+}
+
+// Slice cuts entities to the [start, end) UTF-16 range of a text and makes them relative to start.
+func Slice(entities []tgbot.MessageEntity, start, end int) []tgbot.MessageEntity {
+	var res []tgbot.MessageEntity
+	for _, e := range entities {
+		from, to := e.Offset, e.Offset+e.Length
+		if from < start {
+			from = start
+		}
+		if to > end {
+			to = end
+		}
+		if from >= to {
+			continue
+		}
+		e.Offset = from - start
+		e.Length = to - from
+		res = append(res, e)
+	}
+	return res
+}
+
+// Text is a text with Bot API entities.
+type Text struct {
+	Text     string
+	Entities []tgbot.MessageEntity
+}
+
+// FromTd converts a TDLib formatted text.
+func FromTd(ft tgclient.FormattedText) Text {
+	return Text{Text: ft.Text, Entities: Entities(ft)}
+}
+
+// Concat joins texts keeping entities in place.
+func Concat(texts ...Text) Text {
+	var res Text
+	for _, t := range texts {
+		res.Entities = append(res.Entities, Shift(t.Entities, Len(res.Text))...)
+		res.Text += t.Text
+	}
+	return res
+}
+
+// Plain makes a text without entities.
+func Plain(text string) Text {
+	return Text{Text: text}
+}
+
+// Truncate cuts the text to at most limit characters keeping entities within it.
+func Truncate(t Text, limit int) Text {
+	runes := []rune(t.Text)
+	if len(runes) <= limit {
+		return t
+	}
+	text := string(runes[:limit])
+	return Text{Text: text, Entities: Slice(t.Entities, 0, Len(text))}
+}
+
+// Highlight adds an entity of entityType over every non-empty match of re.
+// Match offsets are converted from bytes to UTF-16 code units.
+func Highlight(t Text, re *regexp.Regexp, entityType string) Text {
+	matches := re.FindAllStringIndex(t.Text, -1)
+	if len(matches) == 0 {
+		return t
+	}
+	entities := make([]tgbot.MessageEntity, len(t.Entities), len(t.Entities)+len(matches))
+	copy(entities, t.Entities)
+	pos, offset := 0, 0
+	for _, m := range matches {
+		if m[0] == m[1] {
+			continue
+		}
+		offset += Len(t.Text[pos:m[0]])
+		length := Len(t.Text[m[0]:m[1]])
+		entities = append(entities, tgbot.MessageEntity{Type: entityType, Offset: offset, Length: length})
+		offset += length
+		pos = m[1]
+	}
+	return Text{Text: t.Text, Entities: entities}
+}
+
+// sortEntities orders entities by start, enclosing entities first.
+func sortEntities(entities []tgbot.MessageEntity) []tgbot.MessageEntity {
+	res := make([]tgbot.MessageEntity, len(entities))
+	copy(res, entities)
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Offset != res[j].Offset {
+			return res[i].Offset < res[j].Offset
+		}
+		return res[i].Length > res[j].Length
+	})
+	return res
+}
+
+type markup interface {
+	escape(text string, inside []tgbot.MessageEntity) string
+	open(e tgbot.MessageEntity) string
+	close(e tgbot.MessageEntity) string
+	supports(e tgbot.MessageEntity) bool
+}
+
+// render walks the text emitting markup for properly nested entities.
+// Entities partially intersecting an enclosing one are dropped.
+func render(t Text, m markup) string {
+	units := utf16.Encode([]rune(t.Text))
+	var entities []tgbot.MessageEntity
+	for _, e := range sortEntities(t.Entities) {
+		if m.supports(e) && e.Length > 0 && e.Offset >= 0 && e.Offset+e.Length <= len(units) {
+			entities = append(entities, e)
+		}
+	}
+
+	var out []byte
+	var stack []tgbot.MessageEntity
+	pos := 0
+	next := 0
+
+	emitText := func(to int) {
+		if to > pos {
+			out = append(out, m.escape(string(utf16.Decode(units[pos:to])), stack)...)
+			pos = to
+		}
+	}
+
+	for pos < len(units) || len(stack) > 0 {
+		boundary := len(units)
+		if len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if end := top.Offset + top.Length; end < boundary {
+				boundary = end
+			}
+		}
+		if next < len(entities) && entities[next].Offset < boundary {
+			boundary = entities[next].Offset
+		}
+		emitText(boundary)
+
+		if len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if top.Offset+top.Length == pos {
+				out = append(out, m.close(top)...)
+				stack = stack[:len(stack)-1]
+				continue
+			}
+		}
+		if next < len(entities) && entities[next].Offset == pos {
+			e := entities[next]
+			next++
+			if len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if e.Offset+e.Length > top.Offset+top.Length {
+					continue
+				}
+			}
+			out = append(out, m.open(e)...)
+			stack = append(stack, e)
+			continue
+		}
+		if pos >= len(units) && len(stack) == 0 {
+			break
+		}
+	}
+	return string(out)
+}
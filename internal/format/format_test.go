@@ -0,0 +1,153 @@
+package format
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"tg-reposter/pkg/tgbot"
+)
+
+func bold(offset, length int) tgbot.MessageEntity {
+	return tgbot.MessageEntity{Type: tgbot.EntityBold, Offset: offset, Length: length}
+}
+
+func italic(offset, length int) tgbot.MessageEntity {
+	return tgbot.MessageEntity{Type: tgbot.EntityItalic, Offset: offset, Length: length}
+}
+
+func TestLen(t *testing.T) {
+	cases := map[string]int{
+		"":      0,
+		"abc":   3,
+		"релиз": 5,
+		"😀":     2,
+		"a😀б":   4,
+		// thumbs up with a skin tone modifier, both outside the BMP
+		"👍🏽": 4,
+	}
+	for text, want := range cases {
+		if got := Len(text); got != want {
+			t.Errorf("%q: got %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	in := Text{Text: "😀😀abc", Entities: []tgbot.MessageEntity{bold(2, 2), italic(4, 3), bold(0, 7)}}
+
+	got := Truncate(in, 3)
+	want := Text{Text: "😀😀a", Entities: []tgbot.MessageEntity{bold(2, 2), italic(4, 1), bold(0, 5)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	// entities past the cut are dropped, the enclosing one ends after the whole surrogate pair
+	got = Truncate(in, 1)
+	want = Text{Text: "😀", Entities: []tgbot.MessageEntity{bold(0, 2)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if got = Truncate(in, 5); !reflect.DeepEqual(got, in) {
+		t.Fatalf("text within the limit changed: %+v", got)
+	}
+}
+
+func TestConcat(t *testing.T) {
+	got := Concat(
+		Plain("😀 "),
+		Text{Text: "bold", Entities: []tgbot.MessageEntity{bold(0, 4)}},
+		Plain(" 👍🏽 "),
+		Text{Text: "б😀", Entities: []tgbot.MessageEntity{italic(1, 2)}},
+	)
+	want := Text{
+		Text:     "😀 bold 👍🏽 б😀",
+		Entities: []tgbot.MessageEntity{bold(3, 4), italic(14, 2)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if HTML(got) != "😀 <b>bold</b> 👍🏽 б<i>😀</i>" {
+		t.Fatalf("html: %s", HTML(got))
+	}
+}
+
+func TestHTML(t *testing.T) {
+	link := tgbot.MessageEntity{Type: tgbot.EntityTextLink, Offset: 0, Length: 2, Url: `https://a.b/?x=1&y="2"`}
+	mention := tgbot.MessageEntity{Type: tgbot.EntityTextMention, Offset: 3, Length: 1, User: &tgbot.User{Id: 42}}
+	pre := tgbot.MessageEntity{Type: tgbot.EntityPre, Offset: 2, Length: 3, Language: "go"}
+	cases := []struct {
+		text     string
+		entities []tgbot.MessageEntity
+		want     string
+	}{
+		{"a < b & c", nil, "a &lt; b &amp; c"},
+		{"😀 bold <x>", []tgbot.MessageEntity{bold(3, 4)}, "😀 <b>bold</b> &lt;x&gt;"},
+		{"hi 👍🏽!", []tgbot.MessageEntity{bold(3, 4)}, "hi <b>👍🏽</b>!"},
+		// nested and adjacent after an emoji
+		{"😀abcdef", []tgbot.MessageEntity{bold(2, 3), italic(3, 1), italic(5, 3)}, "😀<b>a<i>b</i>c</b><i>def</i>"},
+		// the enclosing entity is opened first whatever the order
+		{"😀ab", []tgbot.MessageEntity{italic(2, 1), bold(0, 4)}, "<b>😀<i>a</i>b</b>"},
+		// partially intersecting entities are dropped
+		{"abcde", []tgbot.MessageEntity{bold(0, 3), italic(2, 3)}, "<b>abc</b>de"},
+		{"😀 x", []tgbot.MessageEntity{link, mention}, `<a href="https://a.b/?x=1&amp;y=&#34;2&#34;">😀</a> <a href="tg://user?id=42">x</a>`},
+		{"👍<a>", []tgbot.MessageEntity{pre}, `👍<pre><code class="language-go">&lt;a&gt;</code></pre>`},
+		// out of range and unsupported entities are ignored
+		{"😀", []tgbot.MessageEntity{bold(1, 2), {Type: tgbot.EntityHashtag, Offset: 0, Length: 2}}, "😀"},
+	}
+	for _, c := range cases {
+		if got := HTML(Text{Text: c.text, Entities: c.entities}); got != c.want {
+			t.Errorf("%q %+v:\ngot  %s\nwant %s", c.text, c.entities, got, c.want)
+		}
+	}
+}
+
+func TestMarkdownV2(t *testing.T) {
+	code := tgbot.MessageEntity{Type: tgbot.EntityCode, Offset: 3, Length: 5}
+	underline := tgbot.MessageEntity{Type: tgbot.EntityUnderline, Offset: 5, Length: 2}
+	link := tgbot.MessageEntity{Type: tgbot.EntityTextLink, Offset: 0, Length: 2, Url: `https://a.b/(x)\`}
+	cases := []struct {
+		text     string
+		entities []tgbot.MessageEntity
+		want     string
+	}{
+		{"1.0 (beta)!", nil, `1\.0 \(beta\)\!`},
+		{"😀 a.b_c (x)", []tgbot.MessageEntity{bold(3, 5)}, `😀 *a\.b\_c* \(x\)`},
+		// only ` and \ are escaped inside code
+		{"😀 a_`\\b.", []tgbot.MessageEntity{code}, "😀 `a_\\`\\\\b`\\."},
+		// nested bold inside italic, then adjacent italic and underline
+		{"😀abcdef", []tgbot.MessageEntity{italic(2, 3), bold(3, 1), underline}, "😀_a*b*c_\r__de__f"},
+		{"😀-x", []tgbot.MessageEntity{link, bold(2, 1)}, "[😀](https://a.b/(x\\)\\\\)*\\-*x"},
+		{"👍🏽 end", []tgbot.MessageEntity{{Type: tgbot.EntityPre, Offset: 5, Length: 3, Language: "go"}}, "👍🏽 ```go\nend\n```"},
+	}
+	for _, c := range cases {
+		if got := MarkdownV2(Text{Text: c.text, Entities: c.entities}); got != c.want {
+			t.Errorf("%q %+v:\ngot  %q\nwant %q", c.text, c.entities, got, c.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	in := Text{Text: "😀 Go, 👍🏽 go! релиз go", Entities: []tgbot.MessageEntity{italic(0, 5)}}
+
+	got := Highlight(in, regexp.MustCompile(`(?i)go`), tgbot.EntityBold)
+	want := Text{Text: in.Text, Entities: []tgbot.MessageEntity{italic(0, 5), bold(3, 2), bold(12, 2), bold(22, 2)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if html := HTML(got); html != "<i>😀 <b>Go</b></i>, 👍🏽 <b>go</b>! релиз <b>go</b>" {
+		t.Fatalf("html: %s", html)
+	}
+	if len(in.Entities) != 1 || cap(in.Entities) != 1 {
+		t.Fatalf("source entities changed: %+v", in.Entities)
+	}
+
+	// matches of emoji and empty matches
+	got = Highlight(in, regexp.MustCompile(`👍🏽|x*`), tgbot.EntityUnderline)
+	want = Text{Text: in.Text, Entities: []tgbot.MessageEntity{italic(0, 5),
+		{Type: tgbot.EntityUnderline, Offset: 7, Length: 4}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if got = Highlight(in, regexp.MustCompile(`rust`), tgbot.EntityBold); !reflect.DeepEqual(got, in) {
+		t.Fatalf("text without matches changed: %+v", got)
+	}
+}
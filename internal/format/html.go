@@ -0,0 +1,80 @@
+package format
+
+import (
+	"html"
+	"strconv"
+	"tg-reposter/pkg/tgbot"
+)
+
+type htmlMarkup struct{}
+
+// HTML renders a text for the HTML parse mode.
+func HTML(t Text) string {
+	return render(t, htmlMarkup{})
+}
+
+// EscapeHTML escapes a plain text for the HTML parse mode.
+func EscapeHTML(text string) string {
+	return html.EscapeString(text)
+}
+
+func (htmlMarkup) supports(e tgbot.MessageEntity) bool {
+	switch e.Type {
+	case tgbot.EntityBold, tgbot.EntityItalic, tgbot.EntityUnderline, tgbot.EntityStrikethrough,
+		tgbot.EntityCode, tgbot.EntityPre, tgbot.EntityTextLink, tgbot.EntityTextMention:
+		return true
+	}
+	return false
+}
+
+func (htmlMarkup) escape(text string, inside []tgbot.MessageEntity) string {
+	return html.EscapeString(text)
+}
+
+func (htmlMarkup) open(e tgbot.MessageEntity) string {
+	switch e.Type {
+	case tgbot.EntityBold:
+		return "<b>"
+	case tgbot.EntityItalic:
+		return "<i>"
+	case tgbot.EntityUnderline:
+		return "<u>"
+	case tgbot.EntityStrikethrough:
+		return "<s>"
+	case tgbot.EntityCode:
+		return "<code>"
+	case tgbot.EntityPre:
+		if e.Language != "" {
+			return `<pre><code class="language-` + html.EscapeString(e.Language) + `">`
+		}
+		return "<pre>"
+	case tgbot.EntityTextLink:
+		return `<a href="` + html.EscapeString(e.Url) + `">`
+	case tgbot.EntityTextMention:
+		return `<a href="tg://user?id=` + strconv.Itoa(int(e.User.Id)) + `">`
+	}
+	return ""
+}
+
+func (htmlMarkup) close(e tgbot.MessageEntity) string {
+	switch e.Type {
+	case tgbot.EntityBold:
+		return "</b>"
+	case tgbot.EntityItalic:
+		return "</i>"
+	case tgbot.EntityUnderline:
+		return "</u>"
+	case tgbot.EntityStrikethrough:
+		return "</s>"
+	case tgbot.EntityCode:
+		return "</code>"
+	case tgbot.EntityPre:
+		if e.Language != "" {
+			return "</code></pre>"
+		}
+		return "</pre>"
+	case tgbot.EntityTextLink, tgbot.EntityTextMention:
+		return "</a>"
+	}
+	return ""
+}
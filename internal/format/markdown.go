@@ -0,0 +1,85 @@
+package format
+
+import (
+	"strconv"
+	"strings"
+	"tg-reposter/pkg/tgbot"
+)
+
+type markdownMarkup struct{}
+
+// MarkdownV2 renders a text for the MarkdownV2 parse mode.
+func MarkdownV2(t Text) string {
+	return render(t, markdownMarkup{})
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`,
+	"|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+var markdownCodeEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+
+var markdownUrlEscaper = strings.NewReplacer(`\`, `\\`, ")", `\)`)
+
+// EscapeMarkdownV2 escapes a plain text for the MarkdownV2 parse mode.
+func EscapeMarkdownV2(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+func (markdownMarkup) supports(e tgbot.MessageEntity) bool {
+	return htmlMarkup{}.supports(e)
+}
+
+func (markdownMarkup) escape(text string, inside []tgbot.MessageEntity) string {
+	for _, e := range inside {
+		if e.Type == tgbot.EntityCode || e.Type == tgbot.EntityPre {
+			return markdownCodeEscaper.Replace(text)
+		}
+	}
+	return markdownEscaper.Replace(text)
+}
+
+func (markdownMarkup) open(e tgbot.MessageEntity) string {
+	switch e.Type {
+	case tgbot.EntityBold:
+		return "*"
+	case tgbot.EntityItalic:
+		return "_"
+	case tgbot.EntityUnderline:
+		return "__"
+	case tgbot.EntityStrikethrough:
+		return "~"
+	case tgbot.EntityCode:
+		return "`"
+	case tgbot.EntityPre:
+		return "```" + e.Language + "\n"
+	case tgbot.EntityTextLink, tgbot.EntityTextMention:
+		return "["
+	}
+	return ""
+}
+
+func (markdownMarkup) close(e tgbot.MessageEntity) string {
+	switch e.Type {
+	case tgbot.EntityBold:
+		return "*"
+	case tgbot.EntityItalic:
+		// \r is ignored by Telegram, it separates italic end from an underline end
+		return "_\r"
+	case tgbot.EntityUnderline:
+		return "__"
+	case tgbot.EntityStrikethrough:
+		return "~"
+	case tgbot.EntityCode:
+		return "`"
+	case tgbot.EntityPre:
+		return "\n```"
+	case tgbot.EntityTextLink:
+		return "](" + markdownUrlEscaper.Replace(e.Url) + ")"
+	case tgbot.EntityTextMention:
+		return "](tg://user?id=" + strconv.Itoa(int(e.User.Id)) + ")"
+	}
+	return ""
+}
@@ -0,0 +1,9 @@
+package kvstore
+
+import "github.com/joomcode/errorx"
+
+var Errors = errorx.NewNamespace("kvstore")
+var FileErr = Errors.NewType("file")
+var CorruptErr = Errors.NewType("corrupt")
+var ClosedErr = Errors.NewType("closed")
+var LockedErr = Errors.NewType("locked")
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package kvstore
+
+import "os"
+
+// lockFile is a no-op on Windows.
+func lockFile(f *os.File) error {
+	return nil
+}
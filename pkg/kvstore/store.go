@@ -0,0 +1,327 @@
+// Package kvstore is an embedded key-value store kept in a single append-only file.
+//
+// All data lives in memory, every change is appended to the file and synced.
+// The file is compacted on open and when it grows much larger than the live data,
+// expired values are swept periodically and count as dead data.
+// The file is locked by the process using it.
+package kvstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const compactThreshold = 1000
+
+// sweepInterval is how often expired values are dropped on writes.
+const sweepInterval = time.Minute
+
+type record struct {
+	Op        string `json:"op"`
+	Bucket    string `json:"b"`
+	Key       string `json:"k"`
+	Value     []byte `json:"v,omitempty"`
+	ExpiresAt int64  `json:"e,omitempty"`
+}
+
+type entry struct {
+	value     []byte
+	expiresAt int64
+}
+
+func (e entry) expired(now int64) bool {
+	return e.expiresAt != 0 && e.expiresAt <= now
+}
+
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	lock    *os.File
+	buckets map[string]map[string]entry
+	records int
+	live    int
+	sweptAt int64
+}
+
+// Open loads the store file, creating it if needed. It fails with LockedErr
+// if the file is used by another process.
+func Open(path string) (*Store, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, FileErr.Wrap(err, "store lock failed: %s", path)
+	}
+	err = lockFile(lock)
+	if err != nil {
+		lock.Close()
+		return nil, LockedErr.Wrap(err, "store is used by another process: %s", path)
+	}
+
+	s := &Store{
+		path:    path,
+		lock:    lock,
+		buckets: map[string]map[string]entry{},
+		sweptAt: time.Now().UnixNano(),
+	}
+	err = s.load()
+	if err == nil {
+		err = s.Compact()
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) load() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return FileErr.Wrap(err, "store open failed: %s", s.path)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		rec := record{}
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			// a torn write at the end of the file is expected after a crash
+			return s.checkTail(scanner, line, err)
+		}
+		s.apply(rec)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return FileErr.Wrap(err, "store read failed: %s", s.path)
+	}
+	return nil
+}
+
+func (s *Store) checkTail(scanner *bufio.Scanner, line int, cause error) error {
+	if scanner.Scan() {
+		return CorruptErr.Wrap(cause, "store corrupted: %s, line: %d", s.path, line)
+	}
+	return nil
+}
+
+func (s *Store) apply(rec record) {
+	b := s.buckets[rec.Bucket]
+	_, exists := b[rec.Key]
+	switch rec.Op {
+	case "put":
+		if b == nil {
+			b = map[string]entry{}
+			s.buckets[rec.Bucket] = b
+		}
+		b[rec.Key] = entry{value: rec.Value, expiresAt: rec.ExpiresAt}
+		if !exists {
+			s.live++
+		}
+	case "del":
+		if exists {
+			delete(b, rec.Key)
+			s.live--
+		}
+	}
+}
+
+// Get returns a value, expired values are not returned.
+func (s *Store) Get(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.buckets[bucket][key]
+	if !ok || e.expired(time.Now().UnixNano()) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (s *Store) Put(bucket, key string, value []byte) error {
+	return s.write(record{Op: "put", Bucket: bucket, Key: key, Value: value})
+}
+
+// PutTTL puts a value which expires after ttl.
+func (s *Store) PutTTL(bucket, key string, value []byte, ttl time.Duration) error {
+	expiresAt := time.Now().Add(ttl).UnixNano()
+	return s.write(record{Op: "put", Bucket: bucket, Key: key, Value: value, ExpiresAt: expiresAt})
+}
+
+func (s *Store) Delete(bucket, key string) error {
+	s.mu.Lock()
+	_, ok := s.buckets[bucket][key]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return s.write(record{Op: "del", Bucket: bucket, Key: key})
+}
+
+// GetJSON decodes a value into obj.
+func (s *Store) GetJSON(bucket, key string, obj interface{}) (bool, error) {
+	raw, ok := s.Get(bucket, key)
+	if !ok {
+		return false, nil
+	}
+	err := json.Unmarshal(raw, obj)
+	if err != nil {
+		return true, CorruptErr.Wrap(err, "value decode failed. bucket: %s, key: %s", bucket, key)
+	}
+	return true, nil
+}
+
+// PutJSON encodes obj and puts it.
+func (s *Store) PutJSON(bucket, key string, obj interface{}) error {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return FileErr.Wrap(err, "value encode failed. bucket: %s, key: %s", bucket, key)
+	}
+	return s.Put(bucket, key, raw)
+}
+
+// Keys returns sorted keys of a bucket.
+func (s *Store) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for k, e := range s.buckets[bucket] {
+		if !e.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Store) write(rec record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return ClosedErr.New("store is closed: %s", s.path)
+	}
+
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return FileErr.Wrap(err, "record encode failed")
+	}
+	raw = append(raw, '\n')
+	_, err = s.file.Write(raw)
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		return FileErr.Wrap(err, "store write failed: %s", s.path)
+	}
+
+	s.apply(rec)
+	s.records++
+
+	if now := time.Now().UnixNano(); now-s.sweptAt > int64(sweepInterval) {
+		s.sweepLocked(now)
+	}
+	if s.records > 2*s.live+compactThreshold {
+		return s.compactLocked()
+	}
+	return nil
+}
+
+// sweepLocked drops expired values, their records count as dead until the next compaction.
+func (s *Store) sweepLocked(now int64) {
+	for _, entries := range s.buckets {
+		for key, e := range entries {
+			if e.expired(now) {
+				delete(entries, key)
+				s.live--
+			}
+		}
+	}
+	s.sweptAt = now
+}
+
+// Compact rewrites the file with live values only.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compactLocked()
+}
+
+func (s *Store) compactLocked() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return FileErr.Wrap(err, "store compact failed: %s", s.path)
+	}
+
+	now := time.Now().UnixNano()
+	w := bufio.NewWriter(tmp)
+	records := 0
+	for bucket, entries := range s.buckets {
+		for key, e := range entries {
+			if e.expired(now) {
+				delete(entries, key)
+				s.live--
+				continue
+			}
+			raw, _ := json.Marshal(record{Op: "put", Bucket: bucket, Key: key, Value: e.value, ExpiresAt: e.expiresAt})
+			w.Write(raw)
+			w.WriteByte('\n')
+			records++
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return FileErr.Wrap(err, "store compact failed: %s", s.path)
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return FileErr.Wrap(err, "store open failed: %s", s.path)
+	}
+	s.records = records
+	return nil
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lock != nil {
+		// closing the file releases the lock
+		s.lock.Close()
+		s.lock = nil
+	}
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return FileErr.Wrap(err, "store close failed: %s", s.path)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package kvstore
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/joomcode/errorx"
+)
+
+func openStore(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("open failed: %+v", err)
+	}
+	return s
+}
+
+func TestStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s := openStore(t, path)
+	if err := s.Put("b", "k1", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("b", "k2", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("b", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, path)
+	defer s.Close()
+	if _, ok := s.Get("b", "k1"); ok {
+		t.Fatal("deleted key restored")
+	}
+	if v, ok := s.Get("b", "k2"); !ok || string(v) != "v2" {
+		t.Fatalf("k2: %q, %v", v, ok)
+	}
+}
+
+func TestStoreLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s := openStore(t, path)
+
+	_, err := Open(path)
+	if !errorx.IsOfType(err, LockedErr) {
+		t.Fatalf("second open: %v", err)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = openStore(t, path)
+	_ = s.Close()
+}
+
+func TestStoreCompactsExpiredValues(t *testing.T) {
+	s := openStore(t, filepath.Join(t.TempDir(), "test.db"))
+	defer s.Close()
+
+	for i := 0; i < 2*compactThreshold; i++ {
+		if err := s.PutTTL("b", strconv.Itoa(i), nil, time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	s.sweptAt = 0
+	for i := 0; i < 10; i++ {
+		if err := s.Put("live", strconv.Itoa(i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s.live != 10 || s.records != 10 {
+		t.Fatalf("live: %d, records: %d", s.live, s.records)
+	}
+	if keys := s.Keys("b"); len(keys) != 0 {
+		t.Fatalf("expired keys: %v", keys)
+	}
+}
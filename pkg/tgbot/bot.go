@@ -2,12 +2,21 @@ package tgbot
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
+	"time"
 )
 
-const apiUrl = "https://api.telegram.org/bot%s/%s"
+const DefaultApiUrl = "https://api.telegram.org"
+
+const (
+	methodUrl = "%s/bot%s/%s"
+	fileUrl   = "%s/file/bot%s/%s"
+)
 
 type request map[string]interface{}
 
@@ -17,19 +26,57 @@ func (r request) String() string {
 }
 
 type response struct {
-	Ok          bool            `json:"ok"`
-	Result      json.RawMessage `json:"result"`
-	Description string          `json:"description"`
-	ErrorCode   int             `json:"error_code"`
+	Ok          bool                `json:"ok"`
+	Result      json.RawMessage     `json:"result"`
+	Description string              `json:"description"`
+	ErrorCode   int                 `json:"error_code"`
+	Parameters  *responseParameters `json:"parameters"`
+}
+
+type responseParameters struct {
+	RetryAfter      int   `json:"retry_after"`
+	MigrateToChatId int64 `json:"migrate_to_chat_id"`
 }
 
 type Bot struct {
-	token  string
-	client *http.Client
+	apiUrl     string
+	token      string
+	client     *http.Client
+	pollClient *http.Client
+	limiter    *limiter
+	maxRetries int
+	onMigrate  func(from, to int64)
+
+	migrationsMu sync.Mutex
+	migrations   map[int64]int64
+}
+
+// LogOut logs the bot out from the cloud Bot API server before moving it to a self-hosted one.
+func (b *Bot) LogOut() error {
+	return b.LogOutContext(context.Background())
+}
+
+func (b *Bot) LogOutContext(ctx context.Context) (err error) {
+	_, err = b.doRequest(ctx, "logOut", request{})
+	return
+}
+
+// Close closes the bot instance on a self-hosted server before moving it to another one.
+func (b *Bot) Close() error {
+	return b.CloseContext(context.Background())
+}
+
+func (b *Bot) CloseContext(ctx context.Context) (err error) {
+	_, err = b.doRequest(ctx, "close", request{})
+	return
+}
+
+func (b *Bot) GetMe() (User, error) {
+	return b.GetMeContext(context.Background())
 }
 
-func (b *Bot) GetMe() (u User, err error) {
-	resp, err := b.doRequest("getMe", request{})
+func (b *Bot) GetMeContext(ctx context.Context) (u User, err error) {
+	resp, err := b.doRequest(ctx, "getMe", request{})
 	if err != nil {
 		return
 	}
@@ -40,50 +87,274 @@ func (b *Bot) GetMe() (u User, err error) {
 	return
 }
 
-func (b *Bot) SendMessage(chatId int64, text string) (err error) {
+// GetChat returns a chat by id or @username.
+func (b *Bot) GetChat(chatId string) (Chat, error) {
+	return b.GetChatContext(context.Background(), chatId)
+}
+
+func (b *Bot) GetChatContext(ctx context.Context, chatId string) (ch Chat, err error) {
+	resp, err := b.doRequest(ctx, "getChat", request{"chat_id": chatId})
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(resp.Result, &ch)
+	if err != nil {
+		err = ReqErr.WrapWithNoMessage(err)
+	}
+	return
+}
+
+func (b *Bot) SendMessage(chatId int64, text string) (Message, error) {
+	return b.SendMessageContext(context.Background(), chatId, text)
+}
+
+func (b *Bot) SendMessageContext(ctx context.Context, chatId int64, text string) (Message, error) {
+	return b.SendMessageWithOptionsContext(ctx, chatId, text, SendOptions{})
+}
+
+// SendOptions are optional sendMessage parameters. Entities and ParseMode are mutually exclusive.
+type SendOptions struct {
+	ParseMode             string
+	Entities              []MessageEntity
+	DisableWebPagePreview bool
+	DisableNotification   bool
+	ReplyMarkup           *InlineKeyboardMarkup
+}
+
+func (o SendOptions) apply(req request) {
+	if o.ParseMode != "" {
+		req["parse_mode"] = o.ParseMode
+	}
+	if len(o.Entities) > 0 {
+		req["entities"] = o.Entities
+	}
+	if o.DisableWebPagePreview {
+		req["disable_web_page_preview"] = true
+	}
+	if o.DisableNotification {
+		req["disable_notification"] = true
+	}
+	if o.ReplyMarkup != nil {
+		req["reply_markup"] = o.ReplyMarkup
+	}
+}
+
+func (b *Bot) SendMessageWithOptions(chatId int64, text string, opts SendOptions) (Message, error) {
+	return b.SendMessageWithOptionsContext(context.Background(), chatId, text, opts)
+}
+
+func (b *Bot) SendMessageWithOptionsContext(ctx context.Context, chatId int64, text string, opts SendOptions) (Message, error) {
 	req := request{
 		"chat_id": chatId,
-		"text": text,
+		"text":    text,
 	}
-	_, err = b.doRequest("sendMessage", req)
-	return
+	opts.apply(req)
+	resp, err := b.doRequest(ctx, "sendMessage", req)
+	return decodeMessage(resp, err)
+}
+
+func (b *Bot) ForwardMessage(chatId, fromChatId, messageId int64) (Message, error) {
+	return b.ForwardMessageContext(context.Background(), chatId, fromChatId, messageId)
 }
 
-func (b *Bot) ForwardMessage(chatId, fromChatId, messageId int64) (err error) {
+func (b *Bot) ForwardMessageContext(ctx context.Context, chatId, fromChatId, messageId int64) (Message, error) {
 	req := request{
 		"chat_id":      chatId,
 		"from_chat_id": fromChatId,
 		"message_id":   messageId,
 	}
-	_, err = b.doRequest("forwardMessage", req)
+	resp, err := b.doRequest(ctx, "forwardMessage", req)
+	return decodeMessage(resp, err)
+}
+
+// EditMessageText replaces the text of a sent message. An inline keyboard not passed in opts is removed.
+func (b *Bot) EditMessageText(chatId, messageId int64, text string, opts SendOptions) (Message, error) {
+	return b.EditMessageTextContext(context.Background(), chatId, messageId, text, opts)
+}
+
+func (b *Bot) EditMessageTextContext(ctx context.Context, chatId, messageId int64, text string, opts SendOptions) (Message, error) {
+	req := request{
+		"chat_id":    chatId,
+		"message_id": messageId,
+		"text":       text,
+	}
+	opts.apply(req)
+	resp, err := b.doRequest(ctx, "editMessageText", req)
+	return decodeMessage(resp, err)
+}
+
+// EditMessageCaption replaces the caption of a sent media message, only caption and keyboard options are used.
+func (b *Bot) EditMessageCaption(chatId, messageId int64, opts MediaOptions) (Message, error) {
+	return b.EditMessageCaptionContext(context.Background(), chatId, messageId, opts)
+}
+
+func (b *Bot) EditMessageCaptionContext(ctx context.Context, chatId, messageId int64, opts MediaOptions) (Message, error) {
+	req := request{
+		"chat_id":    chatId,
+		"message_id": messageId,
+	}
+	MediaOptions{
+		Caption:         opts.Caption,
+		ParseMode:       opts.ParseMode,
+		CaptionEntities: opts.CaptionEntities,
+		ReplyMarkup:     opts.ReplyMarkup,
+	}.apply(req)
+	resp, err := b.doRequest(ctx, "editMessageCaption", req)
+	return decodeMessage(resp, err)
+}
+
+// DeleteMessage deletes a message, bots can delete messages sent less than 48 hours ago.
+func (b *Bot) DeleteMessage(chatId, messageId int64) error {
+	return b.DeleteMessageContext(context.Background(), chatId, messageId)
+}
+
+func (b *Bot) DeleteMessageContext(ctx context.Context, chatId, messageId int64) (err error) {
+	req := request{
+		"chat_id":    chatId,
+		"message_id": messageId,
+	}
+	_, err = b.doRequest(ctx, "deleteMessage", req)
 	return
 }
 
-func (b *Bot) doRequest(method string, req request) (resp response, err error) {
-	jsonStr, _ := json.Marshal(req)
+// decodeMessage decodes the message of a successful send or edit response.
+func decodeMessage(resp response, err error) (Message, error) {
+	m := Message{}
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(resp.Result, &m)
+	if err != nil {
+		err = ReqErr.WrapWithNoMessage(err)
+	}
+	return m, err
+}
+
+func (b *Bot) doRequest(ctx context.Context, method string, req request) (resp response, err error) {
+	return b.call(ctx, method, req, nil)
+}
+
+// call sends a request through the rate limiter. A flood control error pauses the limiter for the chat
+// and is retried after the delay given by the server, up to maxRetries times; callers retrying requests
+// themselves set MaxRetries(0). A request to a migrated group is resent once to the new supergroup.
+func (b *Bot) call(ctx context.Context, method string, req request, files map[string]InputFile) (resp response, err error) {
+	limited := b.limiter != nil && isLimited(method)
+	migrated := false
+	for attempt := 0; ; {
+		b.applyMigration(req)
+		chatId := chatKey(req["chat_id"])
+
+		if limited {
+			waitErr := b.limiter.wait(ctx, chatId)
+			if waitErr != nil {
+				if err == nil {
+					err = newReqError(waitErr, method, req)
+				}
+				return
+			}
+		}
+
+		resp, err = b.send(ctx, method, req, files)
+		if err == nil {
+			return
+		}
+
+		if apiErr, ok := AsApiError(err); ok && apiErr.MigrateToChatId != 0 && !migrated {
+			if from, ok := req["chat_id"].(int64); ok {
+				b.setMigration(from, apiErr.MigrateToChatId)
+				migrated = true
+				continue
+			}
+		}
+
+		delay := RetryAfter(err)
+		if delay > 0 && limited {
+			b.limiter.pause(chatId, time.Now().Add(delay))
+		}
+		if delay <= 0 || attempt >= b.maxRetries {
+			return
+		}
+		attempt++
+		if !limited && Sleep(ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+func (b *Bot) send(ctx context.Context, method string, req request, files map[string]InputFile) (response, error) {
+	if files == nil {
+		jsonStr, _ := json.Marshal(req)
+		return b.post(ctx, method, req, bytes.NewBuffer(jsonStr), "application/json")
+	}
+	body, contentType, err := multipartBody(req, files)
+	if err != nil {
+		return response{}, newReqError(err, method, req)
+	}
+	defer body.Close()
+	return b.post(ctx, method, req, body, contentType)
+}
+
+func (b *Bot) applyMigration(req request) {
+	from, ok := req["chat_id"].(int64)
+	if !ok {
+		return
+	}
+	b.migrationsMu.Lock()
+	defer b.migrationsMu.Unlock()
+	if to, ok := b.migrations[from]; ok {
+		req["chat_id"] = to
+	}
+}
+
+func (b *Bot) setMigration(from, to int64) {
+	b.migrationsMu.Lock()
+	b.migrations[from] = to
+	b.migrationsMu.Unlock()
+
+	if b.onMigrate != nil {
+		b.onMigrate(from, to)
+	}
+}
+
+func (b *Bot) post(ctx context.Context, method string, req request, body io.Reader, contentType string) (resp response, err error) {
 	url := b.getUrl(method)
-	buf := bytes.NewBuffer(jsonStr)
 
-	httpResp, err := b.client.Post(url, "application/json", buf)
+	httpReq, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		err = newReqError(err, method, req)
+		return
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+
+	client := b.client
+	if method == "getUpdates" {
+		client = b.pollClient
+	}
+	httpResp, err := client.Do(httpReq.WithContext(ctx))
 
 	if httpResp != nil {
 		defer httpResp.Body.Close()
 	}
 
 	if err != nil {
-		err = newReqError(err, method, req)
+		err = newNetworkError(ctx, err, method, req)
 		return
 	}
-	err = json.NewDecoder(httpResp.Body).Decode(&resp);
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
 	if err != nil {
+		if httpResp.StatusCode >= http.StatusInternalServerError {
+			err = ServerErr.Wrap(err, "request failed. status: %s, method: %s", httpResp.Status, method)
+			return
+		}
 		err = newReqError(err, method, req)
+		return
 	}
 	if !resp.Ok {
-		err = newApiError(err, method, req, resp)
+		err = newApiError(method, req, resp)
 	}
 	return
 }
 
 func (b *Bot) getUrl(method string) string {
-	return fmt.Sprintf(apiUrl, b.token, method)
+	return fmt.Sprintf(methodUrl, b.apiUrl, b.token, method)
 }
@@ -0,0 +1,194 @@
+package tgbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/joomcode/errorx"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestBot returns a bot calling a test server. The handler gets the method name and the request
+// decoded from JSON or a multipart form, uploaded files are passed as their contents, and returns the response body.
+func newTestBot(t *testing.T, builder *Builder, handler func(method string, req request) string) *Bot {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(handler(path.Base(r.URL.Path), decodeTestRequest(t, r))))
+	}))
+	t.Cleanup(srv.Close)
+
+	bot, err := builder.ApiUrl(srv.URL).Token("T").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bot
+}
+
+func decodeTestRequest(t *testing.T, r *http.Request) request {
+	req := request{}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		return req
+	}
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		t.Errorf("multipart parse failed: %v", err)
+		return req
+	}
+	for key, vals := range r.MultipartForm.Value {
+		req[key] = vals[0]
+	}
+	for key, headers := range r.MultipartForm.File {
+		file, err := headers[0].Open()
+		if err != nil {
+			t.Errorf("multipart file open failed: %v", err)
+			continue
+		}
+		raw, _ := ioutil.ReadAll(file)
+		file.Close()
+		req[key] = string(raw)
+	}
+	return req
+}
+
+func TestBuildApiUrl(t *testing.T) {
+	bot, err := NewBuilder().ApiUrl("").Build()
+	if err != nil || bot.apiUrl != DefaultApiUrl {
+		t.Fatalf("empty url: %v, %v", bot, err)
+	}
+	for _, u := range []string{"api.telegram.org", "ftp://example.com", "http://"} {
+		_, err = NewBuilder().ApiUrl(u).Build()
+		if !errorx.IsOfType(err, BuilderErr) {
+			t.Errorf("%s: %v", u, err)
+		}
+	}
+}
+
+func TestCustomApiUrl(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if strings.HasPrefix(r.URL.Path, "/api/file/") {
+			_, _ = w.Write([]byte("file data"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+	}))
+	defer srv.Close()
+
+	bot, err := NewBuilder().ApiUrl(srv.URL + "/api/").Token("T").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err = bot.LogOutContext(ctx); err != nil {
+		t.Fatalf("logOut: %+v", err)
+	}
+	if err = bot.CloseContext(ctx); err != nil {
+		t.Fatalf("close: %+v", err)
+	}
+	var b bytes.Buffer
+	if err = bot.DownloadFileContext(ctx, "photos/file_1.jpg", &b); err != nil {
+		t.Fatalf("download: %+v", err)
+	}
+	if b.String() != "file data" {
+		t.Fatalf("downloaded: %q", b.String())
+	}
+
+	want := []string{"/api/botT/logOut", "/api/botT/close", "/api/file/botT/photos/file_1.jpg"}
+	if strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Fatalf("paths: %v", paths)
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	bot, err := NewBuilder().ApiUrl(srv.URL).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "local.jpg")
+	if err = ioutil.WriteFile(path, []byte("local data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err = bot.DownloadFile(path, &b); err != nil || b.String() != "local data" {
+		t.Fatalf("local download: %q, %+v", b.String(), err)
+	}
+
+	err = bot.DownloadFile("photos/missing.jpg", &b)
+	if !errorx.IsOfType(err, ReqErr) {
+		t.Fatalf("missing file: %+v", err)
+	}
+}
+
+func TestFloodRetry(t *testing.T) {
+	calls := 0
+	bot := newTestBot(t, NewBuilder().NoRateLimits(), func(method string, req request) string {
+		calls++
+		if calls == 1 {
+			return `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`
+		}
+		return `{"ok":true,"result":{"message_id":1}}`
+	})
+	start := time.Now()
+	_, err := bot.SendMessage(1, "hi")
+	if err != nil || calls != 2 {
+		t.Fatalf("send: %d calls, %+v", calls, err)
+	}
+	if time.Since(start) < time.Second {
+		t.Fatal("retried before retry_after")
+	}
+}
+
+func TestFloodNoRetry(t *testing.T) {
+	calls := 0
+	bot := newTestBot(t, NewBuilder().MaxRetries(0), func(method string, req request) string {
+		calls++
+		return `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 5","parameters":{"retry_after":5}}`
+	})
+	_, err := bot.SendMessage(1, "hi")
+	if !errorx.IsOfType(err, FloodErr) || RetryAfter(err) != 5*time.Second || calls != 1 {
+		t.Fatalf("send: %d calls, %+v", calls, err)
+	}
+	if d := bot.limiter.reserve("1"); d < 4*time.Second {
+		t.Fatalf("chat not paused: %s", d)
+	}
+}
+
+func TestChatMigration(t *testing.T) {
+	var chats []float64
+	var migrations [][2]int64
+	builder := NewBuilder().NoRateLimits().MaxRetries(0).OnChatMigrate(func(from, to int64) {
+		migrations = append(migrations, [2]int64{from, to})
+	})
+	bot := newTestBot(t, builder, func(method string, req request) string {
+		chat := req["chat_id"].(float64)
+		chats = append(chats, chat)
+		if chat == -1 {
+			return `{"ok":false,"error_code":400,"description":"Bad Request: group chat was upgraded to a supergroup chat",` +
+				`"parameters":{"migrate_to_chat_id":-1002}}`
+		}
+		return `{"ok":true,"result":{"message_id":1}}`
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := bot.SendMessage(-1, "hi"); err != nil {
+			t.Fatalf("send %d: %+v", i, err)
+		}
+	}
+	if len(chats) != 3 || chats[1] != -1002 || chats[2] != -1002 {
+		t.Fatalf("chats: %v", chats)
+	}
+	if len(migrations) != 1 || migrations[0] != [2]int64{-1, -1002} {
+		t.Fatalf("migrations: %v", migrations)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"golang.org/x/net/proxy"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -16,13 +18,23 @@ type socks5Proxy struct {
 
 
 type Builder struct {
-	token string
-	proxy *socks5Proxy
-	timeout time.Duration
+	apiUrl     string
+	token      string
+	proxy      *socks5Proxy
+	timeout    time.Duration
+	limits     *RateLimits
+	maxRetries int
+	onMigrate  func(from, to int64)
 }
 
+const defaultMaxRetries = 3
+
 func NewBuilder() *Builder {
-	return &Builder{}
+	return &Builder{
+		apiUrl:     DefaultApiUrl,
+		limits:     &DefaultRateLimits,
+		maxRetries: defaultMaxRetries,
+	}
 }
 
 func (b *Builder) Socks5Proxy(host string, port int, login, password string) *Builder {
@@ -35,6 +47,13 @@ func (b *Builder) Socks5Proxy(host string, port int, login, password string) *Bu
 	return b
 }
 
+// ApiUrl sets the Bot API server, e.g. a self-hosted telegram-bot-api or a test server, an empty url keeps DefaultApiUrl.
+// Methods are called at <url>/bot<token>/<method>, files are downloaded from <url>/file/bot<token>/<path>.
+func (b *Builder) ApiUrl(url string) *Builder {
+	b.apiUrl = strings.TrimRight(url, "/")
+	return b
+}
+
 func (b *Builder) Token(token string) *Builder {
 	b.token = token
 	return b
@@ -45,7 +64,41 @@ func (b *Builder) TimeoutSec(secs int) *Builder {
 	return b
 }
 
+// RateLimits sets outbound message limits, DefaultRateLimits are used by default. Sending calls block
+// until the limits allow them, see RateLimits.
+func (b *Builder) RateLimits(limits RateLimits) *Builder {
+	b.limits = &limits
+	return b
+}
+
+// NoRateLimits disables outbound rate limiting.
+func (b *Builder) NoRateLimits() *Builder {
+	b.limits = nil
+	return b
+}
+
+// MaxRetries limits retries of requests failed with flood control errors, zero leaves retries to the caller.
+func (b *Builder) MaxRetries(val int) *Builder {
+	b.maxRetries = val
+	return b
+}
+
+// OnChatMigrate sets a callback called when a group is found migrated to a supergroup.
+func (b *Builder) OnChatMigrate(fn func(from, to int64)) *Builder {
+	b.onMigrate = fn
+	return b
+}
+
 func (b *Builder) Build() (*Bot, error) {
+	apiUrl := b.apiUrl
+	if apiUrl == "" {
+		apiUrl = DefaultApiUrl
+	}
+	u, err := url.Parse(apiUrl)
+	if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return nil, BuilderErr.New("invalid api url: %s", apiUrl)
+	}
+
 	client := http.Client{
 		Timeout: b.timeout,
 	}
@@ -60,10 +113,24 @@ func (b *Builder) Build() (*Bot, error) {
 		}
 	}
 
-	return &Bot{
-		token: b.token,
-		client: &client,
+	// long polling requests outlive the common timeout, their deadline is set by GetUpdates
+	pollClient := client
+	pollClient.Timeout = 0
 
+	var lim *limiter
+	if b.limits != nil {
+		lim = newLimiter(*b.limits)
+	}
+
+	return &Bot{
+		apiUrl:     apiUrl,
+		token:      b.token,
+		client:     &client,
+		pollClient: &pollClient,
+		limiter:    lim,
+		maxRetries: b.maxRetries,
+		onMigrate:  b.onMigrate,
+		migrations: map[int64]int64{},
 	}, nil
 }
 
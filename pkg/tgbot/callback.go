@@ -0,0 +1,73 @@
+package tgbot
+
+import (
+	"context"
+	"strings"
+)
+
+// CallbackAnswerOptions are answerCallbackQuery parameters, Text is shown as a notification or an alert.
+type CallbackAnswerOptions struct {
+	Text      string
+	ShowAlert bool
+	Url       string
+	CacheTime int
+}
+
+func (o CallbackAnswerOptions) apply(req request) {
+	if o.Text != "" {
+		req["text"] = o.Text
+	}
+	if o.ShowAlert {
+		req["show_alert"] = true
+	}
+	if o.Url != "" {
+		req["url"] = o.Url
+	}
+	if o.CacheTime > 0 {
+		req["cache_time"] = o.CacheTime
+	}
+}
+
+// AnswerCallbackQuery must be called for every callback query, otherwise the client shows a progress bar.
+func (b *Bot) AnswerCallbackQuery(queryId string, opts CallbackAnswerOptions) error {
+	return b.AnswerCallbackQueryContext(context.Background(), queryId, opts)
+}
+
+func (b *Bot) AnswerCallbackQueryContext(ctx context.Context, queryId string, opts CallbackAnswerOptions) (err error) {
+	req := request{"callback_query_id": queryId}
+	opts.apply(req)
+	_, err = b.doRequest(ctx, "answerCallbackQuery", req)
+	return
+}
+
+// Callback is a callback query with data in the "action:payload" form, the payload may be empty.
+type Callback struct {
+	Action  string
+	Payload string
+	Query   *CallbackQuery
+	bot     *Bot
+}
+
+// Answer answers the callback query with a notification text, empty text just hides the progress bar.
+func (c Callback) Answer(ctx context.Context, text string) error {
+	return c.bot.AnswerCallbackQueryContext(ctx, c.Query.Id, CallbackAnswerOptions{Text: text})
+}
+
+// CallbackHandler handles a callback query, it is expected to answer it.
+type CallbackHandler func(ctx context.Context, cb Callback) error
+
+// CallbackData builds callback data routed to the action handler.
+func CallbackData(action, payload string) string {
+	if payload == "" {
+		return action
+	}
+	return action + ":" + payload
+}
+
+func parseCallback(q *CallbackQuery) Callback {
+	cb := Callback{Action: q.Data, Query: q}
+	if sep := strings.IndexByte(q.Data, ':'); sep >= 0 {
+		cb.Action, cb.Payload = q.Data[:sep], q.Data[sep+1:]
+	}
+	return cb
+}
@@ -1,17 +1,181 @@
 package tgbot
 
-import "github.com/joomcode/errorx"
+import (
+	"context"
+	"github.com/joomcode/errorx"
+	"strings"
+	"time"
+)
 
 var Errors = errorx.NewNamespace("tgbot")
 var ReqErr = Errors.NewType("request")
 var BuilderErr = Errors.NewType("builder")
+var WebhookErr = Errors.NewType("webhook")
+
+// NetworkErr is a failure to reach the server.
+var NetworkErr = ReqErr.NewSubtype("network", errorx.Temporary())
+
+// ServerErr is a Bot API server failure, 5xx.
+var ServerErr = ReqErr.NewSubtype("server", errorx.Temporary())
+
+// ApiErr is an error returned by Bot API, the details are available through AsApiError.
+var ApiErr = ReqErr.NewSubtype("api")
+
+// FloodErr is a flood control error, 429. The request may be retried after RetryAfter.
+var FloodErr = ApiErr.NewSubtype("flood", errorx.Temporary())
+
+// MigratedErr is returned for a group upgraded to a supergroup, see ApiError.MigrateToChatId.
+var MigratedErr = ApiErr.NewSubtype("migrated")
+
+// NotFoundErr is returned for a missing chat, message or user.
+var NotFoundErr = ApiErr.NewSubtype("not_found", errorx.NotFound())
+
+// ForbiddenErr is returned when the bot may not write to a chat: it was blocked, kicked or the user is deactivated, 403.
+var ForbiddenErr = ApiErr.NewSubtype("forbidden", Forbidden())
+
+// NotModifiedErr is returned for an edit which does not change the message.
+var NotModifiedErr = ApiErr.NewSubtype("not_modified")
+
+var UnauthorizedErr = ApiErr.NewSubtype("unauthorized")
+var BadRequestErr = ApiErr.NewSubtype("bad_request")
+
+var forbiddenTrait = errorx.RegisterTrait("forbidden")
+
+// Forbidden is a trait of errors caused by the bot lacking access to a chat.
+func Forbidden() errorx.Trait {
+	return forbiddenTrait
+}
+
+var apiErrorProperty = errorx.RegisterProperty("api_error")
+
+// ApiError holds the details of a Bot API error response.
+type ApiError struct {
+	Method          string
+	Code            int
+	Description     string
+	RetryAfter      int
+	MigrateToChatId int64
+}
+
+// AsApiError returns the Bot API error details if err is, or is caused by, an ApiErr.
+func AsApiError(err error) (*ApiError, bool) {
+	for err != nil {
+		e := errorx.Cast(err)
+		if e == nil {
+			return nil, false
+		}
+		if val, ok := e.Property(apiErrorProperty); ok {
+			return val.(*ApiError), true
+		}
+		err = e.Cause()
+	}
+	return nil, false
+}
+
+// IsTemporary reports whether a request may succeed if retried.
+func IsTemporary(err error) bool {
+	return hasTrait(err, errorx.Temporary())
+}
+
+// IsNotFound reports whether a chat, message or user is missing.
+func IsNotFound(err error) bool {
+	return hasTrait(err, errorx.NotFound())
+}
+
+// IsForbidden reports whether the bot may not write to a chat.
+func IsForbidden(err error) bool {
+	return hasTrait(err, Forbidden())
+}
+
+// IsNotModified reports whether an edit failed because it does not change the message.
+func IsNotModified(err error) bool {
+	for err != nil {
+		e := errorx.Cast(err)
+		if e == nil {
+			return false
+		}
+		if e.IsOfType(NotModifiedErr) {
+			return true
+		}
+		err = e.Cause()
+	}
+	return false
+}
+
+// RetryAfter returns the delay requested by flood control, zero for other errors.
+func RetryAfter(err error) time.Duration {
+	apiErr, ok := AsApiError(err)
+	if !ok {
+		return 0
+	}
+	return time.Duration(apiErr.RetryAfter) * time.Second
+}
+
+// hasTrait checks the error and its causes, so traits survive wrapping.
+func hasTrait(err error, trait errorx.Trait) bool {
+	for err != nil {
+		if errorx.HasTrait(err, trait) {
+			return true
+		}
+		e := errorx.Cast(err)
+		if e == nil {
+			return false
+		}
+		err = e.Cause()
+	}
+	return false
+}
 
 func newReqError(err error, method string, req request) error {
 	return ReqErr.Wrap(err, "request failed. method: %s, params: %s", method, req.String())
 }
 
-func newApiError(err error, method string, req request, resp response) error {
-	return ReqErr.Wrap(err, "request api failed. code: %d, description: %s, method: %s, req: %s",
-		resp.ErrorCode, resp.Description, method, req.String())
+func newNetworkError(ctx context.Context, err error, method string, req request) error {
+	if ctx.Err() != nil {
+		return newReqError(err, method, req)
+	}
+	return NetworkErr.Wrap(err, "request failed. method: %s, params: %s", method, req.String())
+}
+
+func newDownloadError(err error, filePath string) error {
+	return ReqErr.Wrap(err, "file download failed. path: %s", filePath)
+}
+
+func newApiError(method string, req request, resp response) error {
+	apiErr := &ApiError{
+		Method:      method,
+		Code:        resp.ErrorCode,
+		Description: resp.Description,
+	}
+	if resp.Parameters != nil {
+		apiErr.RetryAfter = resp.Parameters.RetryAfter
+		apiErr.MigrateToChatId = resp.Parameters.MigrateToChatId
+	}
+	return classifyApiError(apiErr).
+		New("request api failed. code: %d, description: %s, method: %s, req: %s",
+			resp.ErrorCode, resp.Description, method, req.String()).
+		WithProperty(apiErrorProperty, apiErr)
 }
 
+func classifyApiError(e *ApiError) *errorx.Type {
+	desc := strings.ToLower(e.Description)
+	switch {
+	case e.Code == 429 || e.RetryAfter > 0:
+		return FloodErr
+	case e.MigrateToChatId != 0:
+		return MigratedErr
+	case e.Code == 401:
+		return UnauthorizedErr
+	case e.Code == 403:
+		return ForbiddenErr
+	case e.Code == 400 && strings.Contains(desc, "message is not modified"):
+		return NotModifiedErr
+	case e.Code == 400 && strings.Contains(desc, "not found"):
+		return NotFoundErr
+	case e.Code == 400:
+		return BadRequestErr
+	case e.Code >= 500:
+		return ServerErr
+	}
+	return ApiErr
+}
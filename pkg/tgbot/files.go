@@ -0,0 +1,73 @@
+package tgbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// GetFile prepares a file for downloading, the returned FilePath is passed to DownloadFile.
+func (b *Bot) GetFile(fileId string) (File, error) {
+	return b.GetFileContext(context.Background(), fileId)
+}
+
+func (b *Bot) GetFileContext(ctx context.Context, fileId string) (f File, err error) {
+	resp, err := b.doRequest(ctx, "getFile", request{"file_id": fileId})
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(resp.Result, &f)
+	if err != nil {
+		err = ReqErr.WrapWithNoMessage(err)
+	}
+	return
+}
+
+// FileUrl returns the download url of a file path.
+func (b *Bot) FileUrl(filePath string) string {
+	return fmt.Sprintf(fileUrl, b.apiUrl, b.token, filePath)
+}
+
+// DownloadFile writes the file contents into w. A self-hosted server in the local mode
+// returns absolute file paths, such files are read from the disk.
+func (b *Bot) DownloadFile(filePath string, w io.Writer) error {
+	return b.DownloadFileContext(context.Background(), filePath, w)
+}
+
+func (b *Bot) DownloadFileContext(ctx context.Context, filePath string, w io.Writer) error {
+	if filepath.IsAbs(filePath) {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return newDownloadError(err, filePath)
+		}
+		defer file.Close()
+		_, err = io.Copy(w, file)
+		if err != nil {
+			return newDownloadError(err, filePath)
+		}
+		return nil
+	}
+
+	httpReq, err := http.NewRequest(http.MethodGet, b.FileUrl(filePath), nil)
+	if err != nil {
+		return newDownloadError(err, filePath)
+	}
+	httpResp, err := b.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return newDownloadError(err, filePath)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return ReqErr.New("file download failed. path: %s, status: %s", filePath, httpResp.Status)
+	}
+	_, err = io.Copy(w, httpResp.Body)
+	if err != nil {
+		return newDownloadError(err, filePath)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package tgbot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimits are outbound message limits, zero means unlimited. Methods sending or editing messages
+// block until both the global and the chat limit allow them; callers are served in call order,
+// so a burst to one chat delays later calls to it by the interval each. A call canceled through
+// its context while waiting still uses its slot. Flood control errors pause the chat for the
+// delay given by the server.
+type RateLimits struct {
+	// Global is messages per second across all chats
+	Global float64
+	// PerChat is messages per second to a private chat
+	PerChat float64
+	// PerGroup is messages per second to a group or a channel
+	PerGroup float64
+	// Burst is how many messages may be sent at once before the rate applies
+	Burst int
+}
+
+// DefaultRateLimits follow Bot API limits: 30 msg/s overall, 1 msg/s per chat, 20 msg/min per group.
+var DefaultRateLimits = RateLimits{
+	Global:   30,
+	PerChat:  1,
+	PerGroup: 20.0 / 60,
+	Burst:    1,
+}
+
+const bucketsSweepSize = 1000
+
+// bucket is a token bucket in the GCRA form: reservations are granted
+// in call order, so waiting callers form a queue.
+type bucket struct {
+	interval time.Duration
+	burst    int
+	tat      time.Time
+}
+
+func newBucket(rate float64, burst int) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{
+		interval: time.Duration(float64(time.Second) / rate),
+		burst:    burst,
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	tat := b.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	allowAt := tat.Add(-b.interval * time.Duration(b.burst-1))
+	b.tat = tat.Add(b.interval)
+	if allowAt.After(now) {
+		return allowAt.Sub(now)
+	}
+	return 0
+}
+
+// pause makes the bucket grant no tokens until t.
+func (b *bucket) pause(t time.Time) {
+	tat := t.Add(b.interval * time.Duration(b.burst-1))
+	if tat.After(b.tat) {
+		b.tat = tat
+	}
+}
+
+func (b *bucket) idle(now time.Time) bool {
+	return b.tat.Before(now)
+}
+
+type limiter struct {
+	limits RateLimits
+
+	mu     sync.Mutex
+	global *bucket
+	chats  map[string]*bucket
+}
+
+func newLimiter(limits RateLimits) *limiter {
+	return &limiter{
+		limits: limits,
+		global: newBucket(limits.Global, limits.Burst),
+		chats:  map[string]*bucket{},
+	}
+}
+
+// wait blocks until a message to chatId may be sent or ctx is done. Empty chatId applies only the global limit.
+// The slot is reserved before waiting and is not returned on cancellation.
+func (l *limiter) wait(ctx context.Context, chatId string) error {
+	d := l.reserve(chatId)
+	if d <= 0 {
+		return nil
+	}
+	return Sleep(ctx, d)
+}
+
+func (l *limiter) reserve(chatId string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var d time.Duration
+	if l.global != nil {
+		d = l.global.reserve(now)
+	}
+	if b := l.chatBucket(chatId, now); b != nil {
+		if cd := b.reserve(now); cd > d {
+			d = cd
+		}
+	}
+	return d
+}
+
+// pause stops sending to chatId, or to all chats if chatId is empty, until t.
+func (l *limiter) pause(chatId string, t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if chatId == "" {
+		if l.global != nil {
+			l.global.pause(t)
+		}
+		return
+	}
+	if b := l.chatBucket(chatId, time.Now()); b != nil {
+		b.pause(t)
+	}
+}
+
+func (l *limiter) chatBucket(chatId string, now time.Time) *bucket {
+	if chatId == "" {
+		return nil
+	}
+	if b, ok := l.chats[chatId]; ok {
+		return b
+	}
+	rate := l.limits.PerChat
+	if isGroupChat(chatId) {
+		rate = l.limits.PerGroup
+	}
+	b := newBucket(rate, l.limits.Burst)
+	if b == nil {
+		return nil
+	}
+	if len(l.chats) >= bucketsSweepSize {
+		for id, old := range l.chats {
+			if old.idle(now) {
+				delete(l.chats, id)
+			}
+		}
+	}
+	l.chats[chatId] = b
+	return b
+}
+
+// isLimited reports whether a method posts messages and so counts against the limits.
+func isLimited(method string) bool {
+	for _, prefix := range []string{"send", "forward", "copy", "edit"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isGroupChat reports whether a Bot API chat id is a group, a supergroup or a channel.
+func isGroupChat(chatId string) bool {
+	return strings.HasPrefix(chatId, "-") || strings.HasPrefix(chatId, "@")
+}
+
+func chatKey(chatId interface{}) string {
+	if chatId == nil {
+		return ""
+	}
+	return fmt.Sprint(chatId)
+}
+
+// Sleep waits for the duration or until ctx is done, it returns the context error in the latter case.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,55 @@
+package tgbot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBucketReserve(t *testing.T) {
+	now := time.Now()
+	b := newBucket(1, 2)
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second}
+	for i, w := range want {
+		if d := b.reserve(now); d != w {
+			t.Fatalf("reserve %d: %s, want %s", i, d, w)
+		}
+	}
+	if d := b.reserve(now.Add(10 * time.Second)); d != 0 {
+		t.Fatalf("reserve after idle: %s", d)
+	}
+
+	b.pause(now.Add(20 * time.Second))
+	if d := b.reserve(now.Add(10 * time.Second)); d != 10*time.Second {
+		t.Fatalf("reserve while paused: %s", d)
+	}
+}
+
+func TestLimiterChats(t *testing.T) {
+	l := newLimiter(RateLimits{PerChat: 1, PerGroup: 0.5, Burst: 1})
+	if d := l.reserve("1"); d != 0 {
+		t.Fatalf("first private: %s", d)
+	}
+	if d := l.reserve("-100"); d != 0 {
+		t.Fatalf("first group: %s", d)
+	}
+	if d := l.reserve("1"); d <= 0 || d > time.Second {
+		t.Fatalf("second private: %s", d)
+	}
+	if d := l.reserve("-100"); d <= time.Second || d > 2*time.Second {
+		t.Fatalf("second group: %s", d)
+	}
+	if d := l.reserve(""); d != 0 {
+		t.Fatalf("no chat: %s", d)
+	}
+}
+
+func TestLimiterWaitCanceled(t *testing.T) {
+	l := newLimiter(RateLimits{PerChat: 0.1, Burst: 1})
+	_ = l.reserve("1")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, "1"); err == nil {
+		t.Fatal("wait not canceled")
+	}
+}
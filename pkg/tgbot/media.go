@@ -0,0 +1,254 @@
+package tgbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// InputFile is a file to send: either a local file to upload or a file_id already known to Telegram.
+type InputFile struct {
+	Path   string
+	FileId string
+}
+
+func (f InputFile) isUpload() bool {
+	return f.FileId == ""
+}
+
+type MediaOptions struct {
+	Caption         string
+	ParseMode       string
+	CaptionEntities []MessageEntity
+	Duration        int32
+	Width           int32
+	Height          int32
+	ReplyMarkup     *InlineKeyboardMarkup
+}
+
+func (o MediaOptions) apply(req request) {
+	if o.Caption != "" {
+		req["caption"] = o.Caption
+	}
+	if o.ParseMode != "" {
+		req["parse_mode"] = o.ParseMode
+	}
+	if len(o.CaptionEntities) > 0 {
+		req["caption_entities"] = o.CaptionEntities
+	}
+	if o.Duration > 0 {
+		req["duration"] = o.Duration
+	}
+	if o.Width > 0 {
+		req["width"] = o.Width
+	}
+	if o.Height > 0 {
+		req["height"] = o.Height
+	}
+	if o.ReplyMarkup != nil {
+		req["reply_markup"] = o.ReplyMarkup
+	}
+}
+
+const (
+	MediaPhoto    = "photo"
+	MediaVideo    = "video"
+	MediaDocument = "document"
+	MediaAudio    = "audio"
+)
+
+// InputMedia is an item of a media group.
+type InputMedia struct {
+	Type            string
+	Media           InputFile
+	Caption         string
+	ParseMode       string
+	CaptionEntities []MessageEntity
+}
+
+func (b *Bot) SendPhoto(chatId int64, photo InputFile, opts MediaOptions) (Message, error) {
+	return b.SendPhotoContext(context.Background(), chatId, photo, opts)
+}
+
+func (b *Bot) SendPhotoContext(ctx context.Context, chatId int64, photo InputFile, opts MediaOptions) (Message, error) {
+	return b.sendFile(ctx, "sendPhoto", "photo", chatId, photo, opts)
+}
+
+func (b *Bot) SendVideo(chatId int64, video InputFile, opts MediaOptions) (Message, error) {
+	return b.SendVideoContext(context.Background(), chatId, video, opts)
+}
+
+func (b *Bot) SendVideoContext(ctx context.Context, chatId int64, video InputFile, opts MediaOptions) (Message, error) {
+	return b.sendFile(ctx, "sendVideo", "video", chatId, video, opts)
+}
+
+func (b *Bot) SendDocument(chatId int64, document InputFile, opts MediaOptions) (Message, error) {
+	return b.SendDocumentContext(context.Background(), chatId, document, opts)
+}
+
+func (b *Bot) SendDocumentContext(ctx context.Context, chatId int64, document InputFile, opts MediaOptions) (Message, error) {
+	return b.sendFile(ctx, "sendDocument", "document", chatId, document, opts)
+}
+
+func (b *Bot) SendAnimation(chatId int64, animation InputFile, opts MediaOptions) (Message, error) {
+	return b.SendAnimationContext(context.Background(), chatId, animation, opts)
+}
+
+func (b *Bot) SendAnimationContext(ctx context.Context, chatId int64, animation InputFile, opts MediaOptions) (Message, error) {
+	return b.sendFile(ctx, "sendAnimation", "animation", chatId, animation, opts)
+}
+
+func (b *Bot) SendVoice(chatId int64, voice InputFile, opts MediaOptions) (Message, error) {
+	return b.SendVoiceContext(context.Background(), chatId, voice, opts)
+}
+
+func (b *Bot) SendVoiceContext(ctx context.Context, chatId int64, voice InputFile, opts MediaOptions) (Message, error) {
+	return b.sendFile(ctx, "sendVoice", "voice", chatId, voice, opts)
+}
+
+func (b *Bot) SendAudio(chatId int64, audio InputFile, opts MediaOptions) (Message, error) {
+	return b.SendAudioContext(context.Background(), chatId, audio, opts)
+}
+
+func (b *Bot) SendAudioContext(ctx context.Context, chatId int64, audio InputFile, opts MediaOptions) (Message, error) {
+	return b.sendFile(ctx, "sendAudio", "audio", chatId, audio, opts)
+}
+
+func (b *Bot) SendSticker(chatId int64, sticker InputFile) (Message, error) {
+	return b.SendStickerContext(context.Background(), chatId, sticker)
+}
+
+func (b *Bot) SendStickerContext(ctx context.Context, chatId int64, sticker InputFile) (Message, error) {
+	return b.sendFile(ctx, "sendSticker", "sticker", chatId, sticker, MediaOptions{})
+}
+
+// SendMediaGroup sends 2-10 photos, videos, documents or audios as an album and returns the sent messages.
+func (b *Bot) SendMediaGroup(chatId int64, media []InputMedia) ([]Message, error) {
+	return b.SendMediaGroupContext(context.Background(), chatId, media)
+}
+
+func (b *Bot) SendMediaGroupContext(ctx context.Context, chatId int64, media []InputMedia) (msgs []Message, err error) {
+	type inputMedia struct {
+		Type            string          `json:"type"`
+		Media           string          `json:"media"`
+		Caption         string          `json:"caption,omitempty"`
+		ParseMode       string          `json:"parse_mode,omitempty"`
+		CaptionEntities []MessageEntity `json:"caption_entities,omitempty"`
+	}
+
+	files := map[string]InputFile{}
+	items := make([]inputMedia, 0, len(media))
+	for i, m := range media {
+		ref := m.Media.FileId
+		if m.Media.isUpload() {
+			name := "file" + strconv.Itoa(i)
+			files[name] = m.Media
+			ref = "attach://" + name
+		}
+		items = append(items, inputMedia{
+			Type:            m.Type,
+			Media:           ref,
+			Caption:         m.Caption,
+			ParseMode:       m.ParseMode,
+			CaptionEntities: m.CaptionEntities,
+		})
+	}
+	rawItems, _ := json.Marshal(items)
+
+	req := request{
+		"chat_id": chatId,
+		"media":   string(rawItems),
+	}
+	resp, err := b.doMultipart(ctx, "sendMediaGroup", req, files)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(resp.Result, &msgs)
+	if err != nil {
+		err = ReqErr.WrapWithNoMessage(err)
+	}
+	return
+}
+
+func (b *Bot) sendFile(ctx context.Context, method, field string, chatId int64, file InputFile, opts MediaOptions) (Message, error) {
+	req := request{
+		"chat_id": chatId,
+	}
+	opts.apply(req)
+
+	if !file.isUpload() {
+		req[field] = file.FileId
+		resp, err := b.doRequest(ctx, method, req)
+		return decodeMessage(resp, err)
+	}
+	resp, err := b.doMultipart(ctx, method, req, map[string]InputFile{field: file})
+	return decodeMessage(resp, err)
+}
+
+func (b *Bot) doMultipart(ctx context.Context, method string, req request, files map[string]InputFile) (resp response, err error) {
+	return b.call(ctx, method, req, files)
+}
+
+// multipartBody streams the request fields and files. Files are opened on every call, so a retried
+// request reads them from the start. The body must be closed to stop the stream.
+func multipartBody(req request, files map[string]InputFile) (io.ReadCloser, string, error) {
+	opened := make(map[string]*os.File, len(files))
+	for field, file := range files {
+		f, err := os.Open(file.Path)
+		if err != nil {
+			closeFiles(opened)
+			return nil, "", err
+		}
+		opened[field] = f
+	}
+
+	r, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	go func() {
+		defer closeFiles(opened)
+		pw.CloseWithError(writeMultipart(w, req, opened))
+	}()
+	return r, w.FormDataContentType(), nil
+}
+
+func writeMultipart(w *multipart.Writer, req request, files map[string]*os.File) error {
+	for key, val := range req {
+		err := w.WriteField(key, formValue(val))
+		if err != nil {
+			return err
+		}
+	}
+	for field, file := range files {
+		part, err := w.CreateFormFile(field, filepath.Base(file.Name()))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, file)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func closeFiles(files map[string]*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+func formValue(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	raw, _ := json.Marshal(val)
+	return string(raw)
+}
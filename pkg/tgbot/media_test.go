@@ -0,0 +1,53 @@
+package tgbot
+
+import (
+	"context"
+	"github.com/joomcode/errorx"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadIsResentOnRetry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := ioutil.WriteFile(path, []byte("image data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var uploads []request
+	bot := newTestBot(t, NewBuilder().NoRateLimits(), func(method string, req request) string {
+		uploads = append(uploads, req)
+		if len(uploads) == 1 {
+			return `{"ok":false,"error_code":400,"description":"Bad Request: group chat was upgraded to a supergroup chat",` +
+				`"parameters":{"migrate_to_chat_id":-1002}}`
+		}
+		return `{"ok":true,"result":{"message_id":7,"chat":{"id":-1002}}}`
+	})
+
+	msg, err := bot.SendPhotoContext(context.Background(), -1, InputFile{Path: path}, MediaOptions{Caption: "hi"})
+	if err != nil {
+		t.Fatalf("send failed: %+v", err)
+	}
+	if msg.MessageId != 7 || len(uploads) != 2 {
+		t.Fatalf("message: %+v, requests: %d", msg, len(uploads))
+	}
+	for _, req := range uploads {
+		if req["photo"] != "image data" || req["caption"] != "hi" {
+			t.Fatalf("upload: %v", req)
+		}
+	}
+	if uploads[1]["chat_id"] != "-1002" {
+		t.Fatalf("retry chat: %v", uploads[1]["chat_id"])
+	}
+}
+
+func TestUploadMissingFile(t *testing.T) {
+	bot := newTestBot(t, NewBuilder().NoRateLimits(), func(method string, req request) string {
+		t.Errorf("unexpected request: %s", method)
+		return `{"ok":true}`
+	})
+	_, err := bot.SendPhotoContext(context.Background(), -1, InputFile{Path: filepath.Join(t.TempDir(), "missing")}, MediaOptions{})
+	if !errorx.IsOfType(err, ReqErr) {
+		t.Fatalf("error: %+v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package tgbot
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Command is a bot command parsed from a message, e.g. "/rules@reposter_bot first second".
+// Username is the addressed bot, empty for commands without @username.
+type Command struct {
+	Name     string
+	Username string
+	Args     []string
+	RawArgs  string
+	Message  *Message
+	bot      *Bot
+}
+
+// Reply sends a text message to the chat the command came from.
+func (c Command) Reply(ctx context.Context, text string) error {
+	_, err := c.bot.SendMessageContext(ctx, c.Message.Chat.Id, text)
+	return err
+}
+
+// ReplyWithOptions sends a text message with options to the chat the command came from.
+func (c Command) ReplyWithOptions(ctx context.Context, text string, opts SendOptions) error {
+	_, err := c.bot.SendMessageWithOptionsContext(ctx, c.Message.Chat.Id, text, opts)
+	return err
+}
+
+type CommandHandler func(ctx context.Context, cmd Command) error
+
+type commandRoute struct {
+	description string
+	handler     CommandHandler
+}
+
+// Router dispatches commands of incoming messages to handlers, it is an UpdateHandler.
+// Commands addressed to another bot with /command@username are ignored once the username is set.
+// Callback queries are dispatched by the action of their data, unhandled ones are answered with an empty text.
+type Router struct {
+	bot       *Bot
+	username  string
+	commands  map[string]commandRoute
+	callbacks map[string]CallbackHandler
+	fallback  UpdateHandler
+	onError   func(ctx context.Context, cmd Command, err error)
+	onUnknown CommandHandler
+
+	onCallbackError func(ctx context.Context, cb Callback, err error)
+}
+
+func NewRouter(bot *Bot) *Router {
+	return &Router{
+		bot:       bot,
+		commands:  map[string]commandRoute{},
+		callbacks: map[string]CallbackHandler{},
+	}
+}
+
+// Username sets the bot username, without @.
+func (r *Router) Username(val string) *Router {
+	r.username = strings.ToLower(val)
+	return r
+}
+
+// Handle registers a command handler, the name is given without the leading slash.
+func (r *Router) Handle(name, description string, handler CommandHandler) *Router {
+	r.commands[strings.ToLower(name)] = commandRoute{
+		description: description,
+		handler:     handler,
+	}
+	return r
+}
+
+// HandleCallback registers a handler of callback queries with the action, see CallbackData.
+func (r *Router) HandleCallback(action string, handler CallbackHandler) *Router {
+	r.callbacks[action] = handler
+	return r
+}
+
+// Fallback sets a handler of updates which are not commands.
+func (r *Router) Fallback(handler UpdateHandler) *Router {
+	r.fallback = handler
+	return r
+}
+
+// OnUnknown sets a handler of commands which are not registered.
+func (r *Router) OnUnknown(handler CommandHandler) *Router {
+	r.onUnknown = handler
+	return r
+}
+
+// OnError sets a callback of failed command handlers.
+func (r *Router) OnError(fn func(ctx context.Context, cmd Command, err error)) *Router {
+	r.onError = fn
+	return r
+}
+
+// OnCallbackError sets a callback of failed callback query handlers.
+func (r *Router) OnCallbackError(fn func(ctx context.Context, cb Callback, err error)) *Router {
+	r.onCallbackError = fn
+	return r
+}
+
+// Commands returns registered commands sorted by name, e.g. for SetMyCommands or a help message.
+func (r *Router) Commands() []BotCommand {
+	commands := make([]BotCommand, 0, len(r.commands))
+	for name, route := range r.commands {
+		commands = append(commands, BotCommand{Command: name, Description: route.description})
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Command < commands[j].Command
+	})
+	return commands
+}
+
+func (r *Router) HandleUpdate(ctx context.Context, u Update) {
+	if u.CallbackQuery != nil {
+		r.handleCallback(ctx, u.CallbackQuery)
+		return
+	}
+	if u.Message == nil {
+		r.handleFallback(ctx, u)
+		return
+	}
+	command, ok := ParseCommand(u.Message)
+	if !ok || command.Username != "" && r.username != "" && command.Username != r.username {
+		r.handleFallback(ctx, u)
+		return
+	}
+	command.bot = r.bot
+
+	handler := r.onUnknown
+	if route, ok := r.commands[command.Name]; ok {
+		handler = route.handler
+	}
+	if handler == nil {
+		return
+	}
+	err := handler(ctx, command)
+	if err != nil && r.onError != nil {
+		r.onError(ctx, command, err)
+	}
+}
+
+func (r *Router) handleCallback(ctx context.Context, q *CallbackQuery) {
+	cb := parseCallback(q)
+	cb.bot = r.bot
+	handler, ok := r.callbacks[cb.Action]
+	if !ok {
+		handler = func(ctx context.Context, cb Callback) error {
+			return cb.Answer(ctx, "")
+		}
+	}
+	err := handler(ctx, cb)
+	if err != nil && r.onCallbackError != nil {
+		r.onCallbackError(ctx, cb, err)
+	}
+}
+
+func (r *Router) handleFallback(ctx context.Context, u Update) {
+	if r.fallback != nil {
+		r.fallback.HandleUpdate(ctx, u)
+	}
+}
+
+// ParseCommand parses a message starting with a bot command. Arguments are split by spaces,
+// double or single quotes keep spaces inside an argument.
+func ParseCommand(msg *Message) (Command, bool) {
+	text := msg.Text
+	if !strings.HasPrefix(text, "/") {
+		return Command{}, false
+	}
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		end = len(text)
+	}
+	name := text[1:end]
+	username := ""
+	if at := strings.IndexByte(name, '@'); at >= 0 {
+		username = strings.ToLower(name[at+1:])
+		name = name[:at]
+	}
+	if name == "" {
+		return Command{}, false
+	}
+	rawArgs := strings.TrimSpace(text[end:])
+	return Command{
+		Name:     strings.ToLower(name),
+		Username: username,
+		Args:     splitArgs(rawArgs),
+		RawArgs:  rawArgs,
+		Message:  msg,
+	}, true
+}
+
+func splitArgs(s string) []string {
+	var args []string
+	var b strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			b.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, b.String())
+				b.Reset()
+				inArg = false
+			}
+		default:
+			b.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, b.String())
+	}
+	return args
+}
@@ -0,0 +1,178 @@
+package tgbot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		text     string
+		ok       bool
+		name     string
+		username string
+		args     []string
+	}{
+		{"/start", true, "start", "", nil},
+		{"/Rules@Reposter_Bot first  second", true, "rules", "reposter_bot", []string{"first", "second"}},
+		{"/mute\tchat:-100 1h", true, "mute", "", []string{"chat:-100", "1h"}},
+		{"/@reposter_bot", false, "", "", nil},
+		{"/", false, "", "", nil},
+		{"hello /start", false, "", "", nil},
+	}
+	for _, c := range cases {
+		cmd, ok := ParseCommand(&Message{Text: c.text})
+		if ok != c.ok || cmd.Name != c.name || cmd.Username != c.username || !reflect.DeepEqual(cmd.Args, c.args) {
+			t.Errorf("%q: %+v, %v", c.text, cmd, ok)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	cases := map[string][]string{
+		``:                         nil,
+		`a b`:                      {"a", "b"},
+		`  a   b  `:                {"a", "b"},
+		`"a b" c`:                  {"a b", "c"},
+		`'it is' "say 'hi'"`:       {"it is", "say 'hi'"},
+		`key="a b"c d`:             {"key=a bc", "d"},
+		`"" x`:                     {"", "x"},
+		`"unterminated quote here`: {"unterminated quote here"},
+		`релиз "новости канала" go`: {"релиз", "новости канала", "go"},
+	}
+	for src, want := range cases {
+		if got := splitArgs(src); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %q, want %q", src, got, want)
+		}
+	}
+}
+
+func TestRouterCommands(t *testing.T) {
+	var handled, unknown, fallback []string
+	router := NewRouter(nil).Username("Reposter_Bot").
+		Handle("rules", "list rules", func(ctx context.Context, cmd Command) error {
+			handled = append(handled, cmd.RawArgs)
+			return nil
+		}).
+		OnUnknown(func(ctx context.Context, cmd Command) error {
+			unknown = append(unknown, cmd.Name)
+			return nil
+		}).
+		Fallback(UpdateHandlerFunc(func(ctx context.Context, u Update) {
+			fallback = append(fallback, u.Message.Text)
+		}))
+
+	texts := []string{
+		"/rules 1",
+		"/rules@reposter_bot 2",
+		"/RULES@Reposter_Bot 3",
+		"/rules@other_bot 4",
+		"/help",
+		"/help@other_bot",
+		"just text",
+	}
+	for _, text := range texts {
+		router.HandleUpdate(context.Background(), Update{Message: &Message{Text: text}})
+	}
+	if !reflect.DeepEqual(handled, []string{"1", "2", "3"}) {
+		t.Errorf("handled: %q", handled)
+	}
+	if !reflect.DeepEqual(unknown, []string{"help"}) {
+		t.Errorf("unknown: %q", unknown)
+	}
+	if !reflect.DeepEqual(fallback, []string{"/rules@other_bot 4", "/help@other_bot", "just text"}) {
+		t.Errorf("fallback: %q", fallback)
+	}
+}
+
+func TestRouterUnknownIgnoredWithoutHandler(t *testing.T) {
+	var failed bool
+	router := NewRouter(nil).
+		Handle("rules", "", func(ctx context.Context, cmd Command) error {
+			return errors.New("failed")
+		}).
+		OnError(func(ctx context.Context, cmd Command, err error) {
+			failed = cmd.Name == "rules"
+		})
+
+	router.HandleUpdate(context.Background(), Update{Message: &Message{Text: "/help"}})
+	if failed {
+		t.Fatal("unknown command dispatched")
+	}
+	router.HandleUpdate(context.Background(), Update{Message: &Message{Text: "/rules"}})
+	if !failed {
+		t.Fatal("handler error not reported")
+	}
+}
+
+func TestRouterCallbacks(t *testing.T) {
+	var mu sync.Mutex
+	var answered []string
+	bot := newTestBot(t, NewBuilder(), func(method string, req request) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if method == "answerCallbackQuery" {
+			answered = append(answered, req["callback_query_id"].(string))
+		}
+		return `{"ok":true,"result":true}`
+	})
+
+	var payloads []string
+	var failed string
+	router := NewRouter(bot).
+		HandleCallback("mute", func(ctx context.Context, cb Callback) error {
+			payloads = append(payloads, cb.Payload)
+			return cb.Answer(ctx, "muted")
+		}).
+		HandleCallback("fail", func(ctx context.Context, cb Callback) error {
+			return errors.New("failed")
+		}).
+		OnCallbackError(func(ctx context.Context, cb Callback, err error) {
+			failed = cb.Query.Id
+		})
+
+	queries := []CallbackQuery{
+		{Id: "1", Data: CallbackData("mute", "chat:-100")},
+		{Id: "2", Data: "mute"},
+		{Id: "3", Data: "unknown:x"},
+		{Id: "4", Data: "fail"},
+	}
+	for i := range queries {
+		router.HandleUpdate(context.Background(), Update{CallbackQuery: &queries[i]})
+	}
+	if !reflect.DeepEqual(payloads, []string{"chat:-100", ""}) {
+		t.Errorf("payloads: %q", payloads)
+	}
+	if failed != "4" {
+		t.Errorf("failed callback: %q", failed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(answered, []string{"1", "2", "3"}) {
+		t.Errorf("answered: %q", answered)
+	}
+}
+
+func TestIsPollFatal(t *testing.T) {
+	cases := []struct {
+		err   error
+		fatal bool
+	}{
+		{newApiError("getUpdates", request{}, response{ErrorCode: 401, Description: "Unauthorized"}), true},
+		{newApiError("getUpdates", request{}, response{ErrorCode: 409,
+			Description: "Conflict: can't use getUpdates method while webhook is active"}), true},
+		// another poller, e.g. the previous instance still shutting down, is waited out
+		{newApiError("getUpdates", request{}, response{ErrorCode: 409,
+			Description: "Conflict: terminated by other getUpdates request"}), false},
+		{newApiError("getUpdates", request{}, response{ErrorCode: 502, Description: "Bad Gateway"}), false},
+		{errors.New("connection reset"), false},
+	}
+	for _, c := range cases {
+		if got := isPollFatal(c.err); got != c.fatal {
+			t.Errorf("%v: got %v, want %v", c.err, got, c.fatal)
+		}
+	}
+}
@@ -1,5 +1,129 @@
 package tgbot
 
 type User struct {
-	Id int32 `json:"id"`
+	Id        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
 }
+
+type File struct {
+	FileId       string `json:"file_id"`
+	FileUniqueId string `json:"file_unique_id"`
+	FileSize     int    `json:"file_size"`
+	FilePath     string `json:"file_path"`
+}
+
+type Chat struct {
+	Id       int64  `json:"id"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Username string `json:"username"`
+}
+
+// MessageEntity is a formatted part of a text. Offset and Length are in UTF-16 code units.
+type MessageEntity struct {
+	Type     string `json:"type"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+	Url      string `json:"url,omitempty"`
+	User     *User  `json:"user,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+const (
+	EntityMention       = "mention"
+	EntityHashtag       = "hashtag"
+	EntityCashtag       = "cashtag"
+	EntityBotCommand    = "bot_command"
+	EntityUrl           = "url"
+	EntityEmail         = "email"
+	EntityPhoneNumber   = "phone_number"
+	EntityBold          = "bold"
+	EntityItalic        = "italic"
+	EntityUnderline     = "underline"
+	EntityStrikethrough = "strikethrough"
+	EntityCode          = "code"
+	EntityPre           = "pre"
+	EntityTextLink      = "text_link"
+	EntityTextMention   = "text_mention"
+)
+
+const (
+	ChatPrivate    = "private"
+	ChatGroup      = "group"
+	ChatSupergroup = "supergroup"
+	ChatChannel    = "channel"
+)
+
+const (
+	ParseModeHTML       = "HTML"
+	ParseModeMarkdownV2 = "MarkdownV2"
+)
+
+// Update is an incoming update, exactly one of the optional fields is set.
+type Update struct {
+	UpdateId          int64          `json:"update_id"`
+	Message           *Message       `json:"message"`
+	EditedMessage     *Message       `json:"edited_message"`
+	ChannelPost       *Message       `json:"channel_post"`
+	EditedChannelPost *Message       `json:"edited_channel_post"`
+	CallbackQuery     *CallbackQuery `json:"callback_query"`
+}
+
+type Message struct {
+	MessageId       int64           `json:"message_id"`
+	From            *User           `json:"from"`
+	Chat            Chat            `json:"chat"`
+	Date            int32           `json:"date"`
+	Text            string          `json:"text"`
+	Entities        []MessageEntity `json:"entities"`
+	Caption         string          `json:"caption"`
+	CaptionEntities []MessageEntity `json:"caption_entities"`
+	MediaGroupId    string          `json:"media_group_id"`
+}
+
+type CallbackQuery struct {
+	Id      string   `json:"id"`
+	From    User     `json:"from"`
+	Message *Message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+// InlineKeyboardMarkup is an inline keyboard attached to a message.
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// InlineKeyboardButton opens Url or sends CallbackData, up to 64 bytes, in a callback query.
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	Url          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+func NewInlineKeyboard(rows ...[]InlineKeyboardButton) *InlineKeyboardMarkup {
+	return &InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+func UrlButton(text, url string) InlineKeyboardButton {
+	return InlineKeyboardButton{Text: text, Url: url}
+}
+
+func CallbackButton(text, data string) InlineKeyboardButton {
+	return InlineKeyboardButton{Text: text, CallbackData: data}
+}
+
+// BotCommand is a command shown in the client command menu.
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+const (
+	UpdateMessage       = "message"
+	UpdateEditedMessage = "edited_message"
+	UpdateChannelPost   = "channel_post"
+	UpdateCallbackQuery = "callback_query"
+)
@@ -0,0 +1,166 @@
+package tgbot
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPollTimeout = 30 * time.Second
+	defaultPollLimit   = 100
+	pollRetryBackoff   = time.Second
+	pollRetryMax       = time.Minute
+	// pollGrace is added to the long polling timeout to get the request deadline.
+	pollGrace = 30 * time.Second
+	// pollConfirmTimeout limits the getUpdates call confirming handled updates on shutdown.
+	pollConfirmTimeout = 5 * time.Second
+)
+
+// UpdateHandler handles incoming updates. Updates are handled one by one in the order they were received.
+type UpdateHandler interface {
+	HandleUpdate(ctx context.Context, u Update)
+}
+
+type UpdateHandlerFunc func(ctx context.Context, u Update)
+
+func (f UpdateHandlerFunc) HandleUpdate(ctx context.Context, u Update) {
+	f(ctx, u)
+}
+
+// GetUpdatesOptions are getUpdates parameters. Timeout is the long polling timeout.
+type GetUpdatesOptions struct {
+	Offset         int64
+	Limit          int
+	Timeout        time.Duration
+	AllowedUpdates []string
+}
+
+func (o GetUpdatesOptions) apply(req request) {
+	if o.Offset != 0 {
+		req["offset"] = o.Offset
+	}
+	if o.Limit > 0 {
+		req["limit"] = o.Limit
+	}
+	if o.Timeout > 0 {
+		req["timeout"] = int(o.Timeout / time.Second)
+	}
+	if o.AllowedUpdates != nil {
+		req["allowed_updates"] = o.AllowedUpdates
+	}
+}
+
+func (b *Bot) GetUpdates(opts GetUpdatesOptions) ([]Update, error) {
+	return b.GetUpdatesContext(context.Background(), opts)
+}
+
+func (b *Bot) GetUpdatesContext(ctx context.Context, opts GetUpdatesOptions) (updates []Update, err error) {
+	req := request{}
+	opts.apply(req)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout+pollGrace)
+		defer cancel()
+	}
+	resp, err := b.doRequest(ctx, "getUpdates", req)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(resp.Result, &updates)
+	if err != nil {
+		err = ReqErr.WrapWithNoMessage(err)
+	}
+	return
+}
+
+// PollOptions configure long polling. AllowedUpdates nil keeps the types set by the previous call.
+// OnError is called for failed getUpdates calls, they are retried with backoff.
+type PollOptions struct {
+	Timeout        time.Duration
+	AllowedUpdates []string
+	OnError        func(err error)
+}
+
+// Poll receives updates with getUpdates long polling and passes them to the handler until ctx is done.
+// The offset is tracked in memory and confirmed with a short getUpdates call when ctx is done,
+// otherwise the last handled updates would be delivered again after a restart.
+// Poll returns an error if the token is revoked or a webhook is set.
+func (b *Bot) Poll(ctx context.Context, handler UpdateHandler, opts PollOptions) error {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultPollTimeout
+	}
+	getOpts := GetUpdatesOptions{
+		Limit:          defaultPollLimit,
+		Timeout:        opts.Timeout,
+		AllowedUpdates: opts.AllowedUpdates,
+	}
+
+	defer func() {
+		b.confirmUpdates(getOpts.Offset)
+	}()
+
+	backoff := pollRetryBackoff
+	for ctx.Err() == nil {
+		updates, err := b.GetUpdatesContext(ctx, getOpts)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			if isPollFatal(err) {
+				return err
+			}
+			if opts.OnError != nil {
+				opts.OnError(err)
+			}
+			if Sleep(ctx, backoff) != nil {
+				break
+			}
+			backoff *= 2
+			if backoff > pollRetryMax {
+				backoff = pollRetryMax
+			}
+			continue
+		}
+		backoff = pollRetryBackoff
+
+		for _, u := range updates {
+			if u.UpdateId >= getOpts.Offset {
+				getOpts.Offset = u.UpdateId + 1
+			}
+			handler.HandleUpdate(ctx, u)
+		}
+	}
+	return nil
+}
+
+// confirmUpdates marks updates before the offset as handled without waiting for new ones.
+func (b *Bot) confirmUpdates(offset int64) {
+	if offset == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pollConfirmTimeout)
+	defer cancel()
+	_, _ = b.GetUpdatesContext(ctx, GetUpdatesOptions{Offset: offset, Limit: 1})
+}
+
+// isPollFatal reports errors polling can not recover from: a revoked token or an active webhook.
+// A conflict with another poller is retried, it usually ends when a previous instance shuts down.
+func isPollFatal(err error) bool {
+	apiErr, ok := AsApiError(err)
+	if !ok {
+		return false
+	}
+	return apiErr.Code == 401 || apiErr.Code == 409 && strings.Contains(apiErr.Description, "webhook")
+}
+
+// SetMyCommands sets the command menu shown by clients.
+func (b *Bot) SetMyCommands(commands []BotCommand) error {
+	return b.SetMyCommandsContext(context.Background(), commands)
+}
+
+func (b *Bot) SetMyCommandsContext(ctx context.Context, commands []BotCommand) (err error) {
+	_, err = b.doRequest(ctx, "setMyCommands", request{"commands": commands})
+	return
+}
@@ -0,0 +1,45 @@
+package tgbot
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestPollConfirmsOffsetOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var offsets []float64
+	bot := newTestBot(t, NewBuilder(), func(method string, req request) string {
+		mu.Lock()
+		defer mu.Unlock()
+		offset, _ := req["offset"].(float64)
+		offsets = append(offsets, offset)
+		if offset == 0 {
+			return `{"ok":true,"result":[{"update_id":5},{"update_id":6}]}`
+		}
+		return `{"ok":true,"result":[]}`
+	})
+
+	var handled []int64
+	handler := UpdateHandlerFunc(func(ctx context.Context, u Update) {
+		handled = append(handled, u.UpdateId)
+		if u.UpdateId == 6 {
+			cancel()
+		}
+	})
+	if err := bot.Poll(ctx, handler, PollOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(handled) != 2 {
+		t.Fatalf("handled: %v", handled)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(offsets) != 2 || offsets[1] != 7 {
+		t.Fatalf("getUpdates offsets: %v", offsets)
+	}
+}
@@ -0,0 +1,193 @@
+package tgbot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+const defaultWebhookShutdownTimeout = 10 * time.Second
+
+// WebhookOptions are setWebhook parameters. SecretToken is sent back by Telegram in SecretTokenHeader.
+type WebhookOptions struct {
+	Url                string
+	SecretToken        string
+	IpAddress          string
+	MaxConnections     int
+	AllowedUpdates     []string
+	DropPendingUpdates bool
+}
+
+func (o WebhookOptions) apply(req request) {
+	req["url"] = o.Url
+	if o.SecretToken != "" {
+		req["secret_token"] = o.SecretToken
+	}
+	if o.IpAddress != "" {
+		req["ip_address"] = o.IpAddress
+	}
+	if o.MaxConnections > 0 {
+		req["max_connections"] = o.MaxConnections
+	}
+	if o.AllowedUpdates != nil {
+		req["allowed_updates"] = o.AllowedUpdates
+	}
+	if o.DropPendingUpdates {
+		req["drop_pending_updates"] = true
+	}
+}
+
+type WebhookInfo struct {
+	Url                  string   `json:"url"`
+	HasCustomCertificate bool     `json:"has_custom_certificate"`
+	PendingUpdateCount   int      `json:"pending_update_count"`
+	IpAddress            string   `json:"ip_address"`
+	LastErrorDate        int32    `json:"last_error_date"`
+	LastErrorMessage     string   `json:"last_error_message"`
+	MaxConnections       int      `json:"max_connections"`
+	AllowedUpdates       []string `json:"allowed_updates"`
+}
+
+func (b *Bot) SetWebhook(opts WebhookOptions) error {
+	return b.SetWebhookContext(context.Background(), opts)
+}
+
+func (b *Bot) SetWebhookContext(ctx context.Context, opts WebhookOptions) (err error) {
+	req := request{}
+	opts.apply(req)
+	_, err = b.doRequest(ctx, "setWebhook", req)
+	return
+}
+
+// DeleteWebhook switches the bot back to getUpdates.
+func (b *Bot) DeleteWebhook(dropPendingUpdates bool) error {
+	return b.DeleteWebhookContext(context.Background(), dropPendingUpdates)
+}
+
+func (b *Bot) DeleteWebhookContext(ctx context.Context, dropPendingUpdates bool) (err error) {
+	req := request{}
+	if dropPendingUpdates {
+		req["drop_pending_updates"] = true
+	}
+	_, err = b.doRequest(ctx, "deleteWebhook", req)
+	return
+}
+
+func (b *Bot) GetWebhookInfo() (WebhookInfo, error) {
+	return b.GetWebhookInfoContext(context.Background())
+}
+
+func (b *Bot) GetWebhookInfoContext(ctx context.Context) (info WebhookInfo, err error) {
+	resp, err := b.doRequest(ctx, "getWebhookInfo", request{})
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(resp.Result, &info)
+	if err != nil {
+		err = ReqErr.WrapWithNoMessage(err)
+	}
+	return
+}
+
+// WebhookHandler is an http.Handler receiving updates sent by Telegram to a webhook.
+// Requests without the expected secret token are rejected, updates are handled one by one.
+type WebhookHandler struct {
+	ctx         context.Context
+	handler     UpdateHandler
+	secretToken string
+	mu          sync.Mutex
+}
+
+// NewWebhookHandler creates a webhook handler passing ctx to the update handler.
+// An empty secretToken disables the token check.
+func NewWebhookHandler(ctx context.Context, handler UpdateHandler, secretToken string) *WebhookHandler {
+	return &WebhookHandler{
+		ctx:         ctx,
+		handler:     handler,
+		secretToken: secretToken,
+	}
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if h.secretToken != "" {
+		token := r.Header.Get(SecretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.secretToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	u := Update{}
+	err := json.NewDecoder(r.Body).Decode(&u)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	h.handler.HandleUpdate(h.ctx, u)
+	h.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// WebhookServerOptions configure the webhook HTTP server. TLS is enabled when CertFile and KeyFile are set,
+// a server behind a reverse proxy usually listens on plain HTTP.
+type WebhookServerOptions struct {
+	Listen          string
+	Path            string
+	SecretToken     string
+	CertFile        string
+	KeyFile         string
+	ShutdownTimeout time.Duration
+}
+
+// ServeWebhook receives updates on an HTTP server until ctx is done, then shuts the server down
+// waiting for requests in progress. The webhook itself is registered with SetWebhook.
+func ServeWebhook(ctx context.Context, handler UpdateHandler, opts WebhookServerOptions) error {
+	path := opts.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, NewWebhookHandler(ctx, handler, opts.SecretToken))
+	server := &http.Server{
+		Addr:    opts.Listen,
+		Handler: mux,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if opts.CertFile != "" && opts.KeyFile != "" {
+			serveErr <- server.ListenAndServeTLS(opts.CertFile, opts.KeyFile)
+		} else {
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return WebhookErr.Wrap(err, "webhook server failed. listen: %s", opts.Listen)
+	case <-ctx.Done():
+	}
+
+	timeout := opts.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultWebhookShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		return WebhookErr.Wrap(err, "webhook server shutdown failed")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package tgbot
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	var handled []int64
+	handler := NewWebhookHandler(context.Background(), UpdateHandlerFunc(func(ctx context.Context, u Update) {
+		handled = append(handled, u.UpdateId)
+	}), "secret")
+
+	cases := []struct {
+		method string
+		token  string
+		body   string
+		status int
+	}{
+		{http.MethodGet, "secret", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "", `{"update_id":1}`, http.StatusUnauthorized},
+		{http.MethodPost, "wrong", `{"update_id":1}`, http.StatusUnauthorized},
+		{http.MethodPost, "secret", `{"update_id":`, http.StatusBadRequest},
+		{http.MethodPost, "secret", `{"update_id":7,"message":{"message_id":3,"text":"hi"}}`, http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/hook", strings.NewReader(c.body))
+		if c.token != "" {
+			req.Header.Set(SecretTokenHeader, c.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Errorf("%s %q %s: status %d, want %d", c.method, c.token, c.body, rec.Code, c.status)
+		}
+	}
+	if len(handled) != 1 || handled[0] != 7 {
+		t.Fatalf("handled: %v", handled)
+	}
+}
+
+func TestWebhookHandlerWithoutToken(t *testing.T) {
+	var handled *Update
+	handler := NewWebhookHandler(context.Background(), UpdateHandlerFunc(func(ctx context.Context, u Update) {
+		handled = &u
+	}), "")
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"update_id":1,"message":{"text":"hi"}}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || handled == nil || handled.Message == nil || handled.Message.Text != "hi" {
+		t.Fatalf("status %d, update %+v", rec.Code, handled)
+	}
+}
+
+func TestServeWebhookShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan int64, 1)
+	handler := UpdateHandlerFunc(func(ctx context.Context, u Update) {
+		updates <- u.UpdateId
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeWebhook(ctx, handler, WebhookServerOptions{Listen: addr, Path: "/hook", ShutdownTimeout: time.Second})
+	}()
+
+	url := "http://" + addr + "/hook"
+	var resp *http.Response
+	for i := 0; i < 100; i++ {
+		resp, err = http.Post(url, "application/json", strings.NewReader(`{"update_id":3}`))
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || <-updates != 3 {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server not shut down")
+	}
+	if _, err = http.Post(url, "application/json", strings.NewReader(`{"update_id":4}`)); err == nil {
+		t.Fatal("server still listening")
+	}
+}
@@ -1,7 +1,13 @@
 package tgclient
 
+import "context"
+
 func (c *Client) GetAuthState() (AuthState, error) {
-	resp, err := c.Send(Request{"@type": "getAuthorizationState"})
+	return c.GetAuthStateContext(context.Background())
+}
+
+func (c *Client) GetAuthStateContext(ctx context.Context) (AuthState, error) {
+	resp, err := c.SendContext(ctx, Request{"@type": "getAuthorizationState"})
 	if err != nil {
 		return "", err
 	}
@@ -16,17 +22,21 @@ func (c *Client) SetLogVerbosity(verbosity int) {
 }
 
 func (c *Client) Authorize() error {
-	state, err := c.GetAuthState()
+	return c.AuthorizeContext(context.Background())
+}
+
+func (c *Client) AuthorizeContext(ctx context.Context) error {
+	state, err := c.GetAuthStateContext(ctx)
 	if err != nil {
 		return err
 	}
 
 	if state == AuthStateWaitTdlibParameters {
-		err = c.setTdLibParameters()
+		err = c.setTdLibParameters(ctx)
 		if err != nil {
 			return err
 		}
-		return c.Authorize()
+		return c.AuthorizeContext(ctx)
 	}
 
 	if c.config.proxy != nil && !c.isProxyAdded() {
@@ -35,35 +45,35 @@ func (c *Client) Authorize() error {
 	}
 
 	if state == AuthStateWaitEncryptionKey {
-		err = c.checkDatabaseEncryptionKey(nil)
+		err = c.checkDatabaseEncryptionKey(ctx, nil)
 		if err != nil {
 			return err
 		}
-		return c.Authorize()
+		return c.AuthorizeContext(ctx)
 	}
 
 	if state == AuthStateWaitPhoneNumber {
-		err = c.setAuthenticationPhoneNumber()
+		err = c.setAuthenticationPhoneNumber(ctx)
 		if err != nil {
 			return err
 		}
-		return c.Authorize()
+		return c.AuthorizeContext(ctx)
 	}
 
 	if state == AuthStateWaitCode {
-		err = c.checkAuthenticationCode()
+		err = c.checkAuthenticationCode(ctx)
 		if err != nil {
 			return err
 		}
-		return c.Authorize()
+		return c.AuthorizeContext(ctx)
 	}
 
 	if state == AuthStateWaitPassword {
-		err = c.checkAuthenticationPassword()
+		err = c.checkAuthenticationPassword(ctx)
 		if err != nil {
 			return err
 		}
-		return c.Authorize()
+		return c.AuthorizeContext(ctx)
 	}
 
 	if state == AuthStateReady {
@@ -72,9 +82,30 @@ func (c *Client) Authorize() error {
 	return AuthErr.New("auth failed. state: " + string(state))
 }
 
-func (c *Client) GetMe() (u User, err error) {
+func (c *Client) GetMe() (User, error) {
+	return c.GetMeContext(context.Background())
+}
+
+func (c *Client) GetMeContext(ctx context.Context) (u User, err error) {
 	r := Request{"@type": "getMe"}
-	ev, err := c.Send(r)
+	ev, err := c.SendContext(ctx, r)
+	if err != nil {
+		return
+	}
+	err = parseResponse(ev, r, &u)
+	return
+}
+
+func (c *Client) GetUser(userId int32) (User, error) {
+	return c.GetUserContext(context.Background(), userId)
+}
+
+func (c *Client) GetUserContext(ctx context.Context, userId int32) (u User, err error) {
+	r := Request{
+		"@type":   "getUser",
+		"user_id": userId,
+	}
+	ev, err := c.SendContext(ctx, r)
 	if err != nil {
 		return
 	}
@@ -83,6 +114,10 @@ func (c *Client) GetMe() (u User, err error) {
 }
 
 func (c *Client) GetChats(offsetOrder, offsetChatId, limit int64) ([]int64, error) {
+	return c.GetChatsContext(context.Background(), offsetOrder, offsetChatId, limit)
+}
+
+func (c *Client) GetChatsContext(ctx context.Context, offsetOrder, offsetChatId, limit int64) ([]int64, error) {
 	type rawChats struct {
 		ChatIds []int64 `json:"chat_ids"`
 	}
@@ -92,7 +127,7 @@ func (c *Client) GetChats(offsetOrder, offsetChatId, limit int64) ([]int64, erro
 		"offset_chat_id": offsetChatId,
 		"limit":          limit,
 	}
-	ev, err := c.Send(r)
+	ev, err := c.SendContext(ctx, r)
 	if err != nil {
 		return nil, err
 	}
@@ -101,12 +136,16 @@ func (c *Client) GetChats(offsetOrder, offsetChatId, limit int64) ([]int64, erro
 	return chats.ChatIds, err
 }
 
-func (c *Client) GetChat(chatId int64) (ch Chat, err error) {
+func (c *Client) GetChat(chatId int64) (Chat, error) {
+	return c.GetChatContext(context.Background(), chatId)
+}
+
+func (c *Client) GetChatContext(ctx context.Context, chatId int64) (ch Chat, err error) {
 	r := Request{
 		"@type":   "getChat",
 		"chat_id": chatId,
 	}
-	ev, err := c.Send(r)
+	ev, err := c.SendContext(ctx, r)
 	if err != nil {
 		return
 	}
@@ -114,7 +153,63 @@ func (c *Client) GetChat(chatId int64) (ch Chat, err error) {
 	return
 }
 
-func (c *Client) GetChatHistory(chatId int64, fromMsgId int64, offset int, limit int) (m Messages, err error) {
+func (c *Client) GetMessage(chatId, messageId int64) (Message, error) {
+	return c.GetMessageContext(context.Background(), chatId, messageId)
+}
+
+func (c *Client) GetMessageContext(ctx context.Context, chatId, messageId int64) (m Message, err error) {
+	r := Request{
+		"@type":      "getMessage",
+		"chat_id":    chatId,
+		"message_id": messageId,
+	}
+	ev, err := c.SendContext(ctx, r)
+	if err != nil {
+		return
+	}
+	err = parseResponse(ev, r, &m)
+	return
+}
+
+func (c *Client) SearchPublicChat(username string) (Chat, error) {
+	return c.SearchPublicChatContext(context.Background(), username)
+}
+
+func (c *Client) SearchPublicChatContext(ctx context.Context, username string) (ch Chat, err error) {
+	r := Request{
+		"@type":    "searchPublicChat",
+		"username": username,
+	}
+	ev, err := c.SendContext(ctx, r)
+	if err != nil {
+		return
+	}
+	err = parseResponse(ev, r, &ch)
+	return
+}
+
+func (c *Client) GetSupergroup(supergroupId int32) (Supergroup, error) {
+	return c.GetSupergroupContext(context.Background(), supergroupId)
+}
+
+func (c *Client) GetSupergroupContext(ctx context.Context, supergroupId int32) (g Supergroup, err error) {
+	r := Request{
+		"@type":         "getSupergroup",
+		"supergroup_id": supergroupId,
+	}
+	ev, err := c.SendContext(ctx, r)
+	if err != nil {
+		return
+	}
+	err = parseResponse(ev, r, &g)
+	return
+}
+
+func (c *Client) GetChatHistory(chatId int64, fromMsgId int64, offset int, limit int) (Messages, error) {
+	return c.GetChatHistoryContext(context.Background(), chatId, fromMsgId, offset, limit)
+}
+
+func (c *Client) GetChatHistoryContext(ctx context.Context, chatId int64, fromMsgId int64, offset int, limit int) (m Messages, err error) {
 	r := Request{
 		"@type":           "getChatHistory",
 		"chat_id":         chatId,
@@ -122,7 +217,7 @@ func (c *Client) GetChatHistory(chatId int64, fromMsgId int64, offset int, limit
 		"offset":          offset,
 		"limit":           limit,
 	}
-	ev, err := c.Send(r)
+	ev, err := c.SendContext(ctx, r)
 	if err != nil {
 		return
 	}
@@ -131,12 +226,13 @@ func (c *Client) GetChatHistory(chatId int64, fromMsgId int64, offset int, limit
 }
 
 func (c *Client) ListenNewMessages() <-chan Message {
-	eventCh := c.addEventChannel(NewMessageUpdateType)
+	sub := c.Subscribe(NewMessageUpdateType)
 
 	ch := make(chan Message)
 
 	go func() {
-		for ev := range eventCh {
+		defer close(ch)
+		for ev := range sub.Events() {
 			update := NewMessageUpdate{}
 			err := ev.Unmarshal(&update)
 			if err != nil {
@@ -150,62 +246,69 @@ func (c *Client) ListenNewMessages() <-chan Message {
 	return ch
 }
 
-func (c *Client) addEventChannel(t ClassType) chan Event {
-	c.eventsMu.Lock()
-	defer c.eventsMu.Unlock()
+// DownloadFile downloads a file into the files directory and waits for completion.
+func (c *Client) DownloadFile(fileId int32) (File, error) {
+	return c.DownloadFileContext(context.Background(), fileId)
+}
 
-	_, ok := c.events[NewMessageUpdateType]
-	if ok {
-		panic("already listening event: " + NewMessageUpdateType)
+func (c *Client) DownloadFileContext(ctx context.Context, fileId int32) (f File, err error) {
+	r := Request{
+		"@type":       "downloadFile",
+		"file_id":     fileId,
+		"priority":    1,
+		"offset":      0,
+		"limit":       0,
+		"synchronous": true,
 	}
-
-	ch := make(chan Event)
-	c.events[NewMessageUpdateType] = ch
-
-	return ch
+	ev, err := c.SendContext(ctx, r)
+	if err != nil {
+		return
+	}
+	err = parseResponse(ev, r, &f)
+	return
 }
 
-func (c *Client) checkAuthenticationPassword() error {
+func (c *Client) checkAuthenticationPassword(ctx context.Context) error {
 	data := Request{
 		"@type":    "checkAuthenticationPassword",
 		"password": c.config.password,
 	}
-	_, err := c.Send(data)
+	_, err := c.SendContext(ctx, data)
 	return err
 }
 
-func (c *Client) checkAuthenticationCode() error {
+func (c *Client) checkAuthenticationCode(ctx context.Context) error {
 	data := Request{
 		"@type":      "checkAuthenticationCode",
 		"code":       c.config.checkCode,
 		"first_name": "",
 		"last_name":  "",
 	}
-	_, err := c.Send(data)
+	_, err := c.SendContext(ctx, data)
 	return err
 }
 
-func (c *Client) setAuthenticationPhoneNumber() error {
+func (c *Client) setAuthenticationPhoneNumber(ctx context.Context) error {
 	data := Request{
 		"@type":                   "setAuthenticationPhoneNumber",
 		"phone_number":            c.config.authPhone,
 		"allow_flash_call":        false,
 		"is_current_phone_number": false,
 	}
-	_, err := c.Send(data)
+	_, err := c.SendContext(ctx, data)
 	return err
 }
 
-func (c *Client) checkDatabaseEncryptionKey(key []byte) error {
+func (c *Client) checkDatabaseEncryptionKey(ctx context.Context, key []byte) error {
 	data := Request{
 		"@type":          "checkDatabaseEncryptionKey",
 		"encryption_key": key,
 	}
-	_, err := c.Send(data)
+	_, err := c.SendContext(ctx, data)
 	return err
 }
 
-func (c *Client) setTdLibParameters() error {
+func (c *Client) setTdLibParameters(ctx context.Context) error {
 	data := Request{
 		"@type": "setTdlibParameters",
 		"parameters": Request{
@@ -227,7 +330,7 @@ func (c *Client) setTdLibParameters() error {
 			"ignore_file_names":        false,
 		},
 	}
-	_, err := c.Send(data)
+	_, err := c.SendContext(ctx, data)
 	return err
 }
 
@@ -18,6 +18,8 @@ type config struct {
 	checkCode          string
 	password           string
 	proxy              *socks5Proxy
+	transport          TransportFactory
+	updates            SubscribeOptions
 }
 
 type socks5Proxy struct {
@@ -101,6 +103,24 @@ func (b *Builder) Password(val string) *Builder {
 	return b
 }
 
+// Transport overrides the TDLib transport, libtdjson is used by default.
+func (b *Builder) Transport(val TransportFactory) *Builder {
+	b.config.transport = val
+	return b
+}
+
+// UpdateBufferSize sets the default queue size of update subscriptions.
+func (b *Builder) UpdateBufferSize(val int) *Builder {
+	b.config.updates.BufferSize = val
+	return b
+}
+
+// UpdateOverflowPolicy sets the default overflow policy of update subscriptions.
+func (b *Builder) UpdateOverflowPolicy(val OverflowPolicy) *Builder {
+	b.config.updates.Overflow = val
+	return b
+}
+
 func (b *Builder) Build() *Client {
 	return newClient(b.config)
 	//if b.proxy != nil {
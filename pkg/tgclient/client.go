@@ -1,31 +1,22 @@
 package tgclient
 
-/*
-#cgo LDFLAGS: -ltdjson
-#include <stdlib.h>
-#include <td/telegram/td_json_client.h>
-#include <td/telegram/td_log.h>
-*/
-import "C"
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"github.com/sirupsen/logrus"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
-	"unsafe"
 )
 
 var ReceiveTimeout = 10.0
 var RequestTimeout = time.Second * 300000
 
-
 type Client struct {
 	logger     *logrus.Entry
 	config     config
-	client     unsafe.Pointer
+	transport  Transport
 	idGen      uint64
 	closed     int32
 	proxyAdded int32
@@ -33,60 +24,130 @@ type Client struct {
 	reqMu    sync.Mutex
 	requests map[uint64]chan Event
 
-	eventsMu sync.Mutex
-	events   map[ClassType]chan Event
+	events   *dispatcher
+	loopDone chan struct{}
 }
 
 func newClient(config config) *Client {
+	newTransport := config.transport
+	if newTransport == nil {
+		newTransport = defaultTransport
+	}
+	if newTransport == nil {
+		panic("tgclient: no transport available, build with cgo and libtdjson or set Builder.Transport")
+	}
+	logger := logrus.WithField("logger", "tgclient")
 	client := &Client{
-		logger:   logrus.WithField("logger", "tgclient"),
-		config:   config,
-		client:   C.td_json_client_create(),
-		reqMu:    sync.Mutex{},
-		requests: map[uint64]chan Event{},
-		eventsMu: sync.Mutex{},
-		events:   map[ClassType]chan Event{},
+		logger:    logger,
+		config:    config,
+		transport: newTransport(),
+		reqMu:     sync.Mutex{},
+		requests:  map[uint64]chan Event{},
+		events:    newDispatcher(logger, config.updates),
+		loopDone:  make(chan struct{}),
 	}
 	go client.updateLoop()
 	return client
 }
 
+// Destroy stops the update loop and releases the transport. All subscriptions are closed.
 func (c *Client) Destroy() {
-	C.td_json_client_destroy(c.client)
-	c.setClosed()
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return
+	}
+	<-c.loopDone
+	c.transport.Destroy()
+	c.events.closeAll()
+}
+
+// Close asks TDLib to close the instance, flushing its database, and waits
+// for authorizationStateClosed. The client is destroyed afterwards in any case.
+func (c *Client) Close(ctx context.Context) error {
+	defer c.Destroy()
+
+	sub := c.SubscribeWithOptions(AuthorizationStateUpdateType, SubscribeOptions{
+		BufferSize: 16,
+		Overflow:   OverflowDropOldest,
+	})
+	defer sub.Unsubscribe()
+
+	_, err := c.SendContext(ctx, Request{"@type": "close"})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return CloseErr.New("client destroyed before close")
+			}
+			update := AuthorizationStateUpdate{}
+			err = ev.Unmarshal(&update)
+			if err != nil {
+				return err
+			}
+			c.logger.Debugf("auth state: %s", update.AuthState())
+			if update.AuthState() == AuthStateClosed {
+				return nil
+			}
+		case <-ctx.Done():
+			return TimeoutErr.New("close timeout")
+		}
+	}
+}
+
+// Subscribe creates a queue of updates of type t with the client default options.
+func (c *Client) Subscribe(t ClassType) *Subscription {
+	return c.events.subscribe(t, c.events.defaults)
+}
+
+// SubscribeWithOptions creates a queue of updates of type t.
+func (c *Client) SubscribeWithOptions(t ClassType, opts SubscribeOptions) *Subscription {
+	return c.events.subscribe(t, opts)
 }
 
 func (c *Client) Send(r Request) (Event, error) {
+	return c.SendContext(context.Background(), r)
+}
+
+// SendContext sends a request and waits for the response until ctx is done.
+// RequestTimeout is applied if ctx has no deadline.
+func (c *Client) SendContext(ctx context.Context, r Request) (Event, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, RequestTimeout)
+		defer cancel()
+	}
+
 	id := atomic.AddUint64(&c.idGen, 1)
 
-	req := C.CString(c.prepareRequest(id, r))
-	defer C.free(unsafe.Pointer(req))
+	req := c.prepareRequest(id, r)
 
 	wait := c.newWaitChan(id)
+	defer c.removeWaitChan(id)
 
-	C.td_json_client_send(c.client, req)
-
-	resp, err := c.waitResponse(r, wait)
-	c.removeWaitChan(id)
+	c.transport.Send(req)
 
-	return resp, err
+	return c.waitResponse(ctx, r, wait)
 }
 
 func (c *Client) SendAndForget(r Request) {
-	req := C.CString(c.prepareRequest(0, r))
-	defer C.free(unsafe.Pointer(req))
-	C.td_json_client_send(c.client, req)
+	c.transport.Send(c.prepareRequest(0, r))
 }
 
-func (c *Client) waitResponse(req Request, ch chan Event) (Event, error) {
+func (c *Client) waitResponse(ctx context.Context, req Request, ch chan Event) (Event, error) {
 	select {
 	case resp := <-ch:
 		if resp.Type == ErrorEventType {
 			return Event{}, c.handleError(req, resp)
 		}
 		return resp, nil
-	case <-time.After(RequestTimeout):
-		return Event{}, TimeoutErr.New("req timeout: " + req.String())
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return Event{}, TimeoutErr.New("req timeout: " + req.String())
+		}
+		return Event{}, CancelErr.New("req canceled: " + req.String())
 	}
 }
 
@@ -96,20 +157,20 @@ func (c *Client) handleError(req Request, raw Event) error {
 	if err != nil {
 		return err
 	}
-	msg := fmt.Sprintf("req failed. code: %d, msg: %s, reg: %s", errorEv.Code, errorEv.Message, req.String())
-	return RequestErr.New(msg)
+	return newRequestError(errorEv, req)
 }
 
-func (c *Client) prepareRequest(id uint64, data Request) string {
+func (c *Client) prepareRequest(id uint64, data Request) []byte {
 	data["@extra"] = strconv.FormatUint(id, 10)
 	req, _ := json.Marshal(data)
-	return string(req)
+	return req
 }
 
 func (c *Client) newWaitChan(id uint64) chan Event {
 	c.reqMu.Lock()
 	defer c.reqMu.Unlock()
-	wait := make(chan Event)
+	// buffered, so a response to an abandoned request never blocks the update loop
+	wait := make(chan Event, 1)
 	c.requests[id] = wait
 	return wait
 }
@@ -121,6 +182,7 @@ func (c *Client) removeWaitChan(id uint64) {
 }
 
 func (c *Client) updateLoop() {
+	defer close(c.loopDone)
 	for !c.checkClosed() {
 		event, err := c.receive(ReceiveTimeout)
 		if err != nil {
@@ -138,10 +200,6 @@ func (c *Client) checkClosed() bool {
 	return v == 1
 }
 
-func (c *Client) setClosed() {
-	atomic.StoreInt32(&c.closed, 1)
-}
-
 func (c *Client) handleEvent(ev Event) {
 	if ev.Extra == "" {
 		c.fireEvent(ev)
@@ -157,13 +215,7 @@ func (c *Client) handleEvent(ev Event) {
 
 func (c *Client) fireEvent(ev Event) {
 	c.logger.Tracef("event: %s", ev)
-
-	c.eventsMu.Lock()
-	defer c.eventsMu.Unlock()
-
-	if ch, ok := c.events[ev.Type]; ok {
-		ch <- ev
-	}
+	c.events.dispatch(ev)
 }
 
 func (c *Client) handleResponse(id uint64, ev Event) {
@@ -176,13 +228,13 @@ func (c *Client) handleResponse(id uint64, ev Event) {
 }
 
 func (c *Client) receive(timeout float64) (*Event, error) {
-	resp := C.td_json_client_receive(c.client, C.double(timeout))
+	resp := c.transport.Receive(timeout)
 	if resp == nil {
 		return nil, nil
 	}
 
 	raw := rawEvent{}
-	contents := json.RawMessage([]byte(C.GoString(resp)))
+	contents := json.RawMessage(resp)
 
 	err := json.Unmarshal(contents, &raw)
 	if err != nil {
@@ -0,0 +1,162 @@
+package tgclient_test
+
+import (
+	"context"
+	"github.com/joomcode/errorx"
+	"os"
+	"testing"
+	"tg-reposter/pkg/tgclient"
+	"tg-reposter/pkg/tgclient/tdfake"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// the update loop checks for Destroy between receives
+	tgclient.ReceiveTimeout = 0.01
+	os.Exit(m.Run())
+}
+
+func newTestClient(t *testing.T, builder *tgclient.Builder) (*tgclient.Client, *tdfake.Transport) {
+	t.Helper()
+	td := tdfake.NewTransport()
+	client := builder.Transport(td.Factory()).Build()
+	t.Cleanup(client.Destroy)
+	return client, td
+}
+
+func TestSend(t *testing.T) {
+	client, td := newTestClient(t, tgclient.NewBuilder())
+	td.Respond("getMe", tdfake.Object{"@type": "user", "id": 42, "username": "alice"})
+	td.Respond("getChat", tdfake.Error(400, "Chat not found"))
+	td.Respond("getUser", tdfake.Error(429, "Too Many Requests: retry after 5"))
+	td.Respond("getMessage", nil)
+
+	me, err := client.GetMe()
+	if err != nil || me.Id != 42 || me.UserName != "alice" {
+		t.Fatalf("getMe: %+v, %+v", me, err)
+	}
+	if req := td.SentOfType("getMe")[0]; req["@extra"] == nil {
+		t.Fatalf("request without extra: %v", req)
+	}
+
+	_, err = client.GetChat(-100)
+	if !tgclient.IsNotFound(err) {
+		t.Fatalf("getChat: %+v", err)
+	}
+	_, err = client.GetUser(1)
+	if !tgclient.IsFloodWait(err) || tgclient.FloodWait(err) != 5*time.Second {
+		t.Fatalf("getUser: %+v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = client.GetMessageContext(ctx, 1, 1)
+	if !errorx.IsOfType(err, tgclient.TimeoutErr) {
+		t.Fatalf("getMessage: %+v", err)
+	}
+}
+
+func TestUpdateLoop(t *testing.T) {
+	client, td := newTestClient(t, tgclient.NewBuilder())
+	sub := client.Subscribe(tgclient.NewMessageUpdateType)
+
+	td.Push(tdfake.Object{"@type": "updateUser"})
+	td.Push(tdfake.Object{"@type": "updateNewMessage", "message": tdfake.Object{"id": 1}})
+	td.Push(tdfake.Object{"@type": "updateNewMessage", "message": tdfake.Object{"id": 2}})
+
+	for _, id := range []int64{1, 2} {
+		select {
+		case ev := <-sub.Events():
+			update := tgclient.NewMessageUpdate{}
+			if err := ev.Unmarshal(&update); err != nil || update.Message.Id != id {
+				t.Fatalf("update: %+v, %v", update, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("update %d not delivered", id)
+		}
+	}
+
+	client.Destroy()
+	if _, ok := <-sub.Events(); ok {
+		t.Fatal("subscription open after destroy")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	builder := tgclient.NewBuilder().AuthPhone("+100").CheckCode("12345").Password("secret")
+	client, td := newTestClient(t, builder)
+
+	states := []tgclient.AuthState{
+		tgclient.AuthStateWaitTdlibParameters,
+		tgclient.AuthStateWaitEncryptionKey,
+		tgclient.AuthStateWaitPhoneNumber,
+		tgclient.AuthStateWaitCode,
+		tgclient.AuthStateWaitPassword,
+		tgclient.AuthStateReady,
+	}
+	state := 0
+	td.Handle("getAuthorizationState", func(tdfake.Object) interface{} {
+		return tdfake.Object{"@type": string(states[state])}
+	})
+	next := func(tdfake.Object) interface{} {
+		state++
+		return tdfake.Ok()
+	}
+	steps := []string{"setTdlibParameters", "checkDatabaseEncryptionKey", "setAuthenticationPhoneNumber",
+		"checkAuthenticationCode", "checkAuthenticationPassword"}
+	for _, step := range steps {
+		td.Handle(step, next)
+	}
+
+	if err := client.Authorize(); err != nil {
+		t.Fatalf("authorize: %+v", err)
+	}
+	if state != len(states)-1 {
+		t.Fatalf("stopped at %s", states[state])
+	}
+	if td.SentOfType("setAuthenticationPhoneNumber")[0]["phone_number"] != "+100" ||
+		td.SentOfType("checkAuthenticationCode")[0]["code"] != "12345" ||
+		td.SentOfType("checkAuthenticationPassword")[0]["password"] != "secret" {
+		t.Fatalf("unexpected auth requests: %v", td.Sent())
+	}
+}
+
+func TestAuthorizeFails(t *testing.T) {
+	client, td := newTestClient(t, tgclient.NewBuilder())
+	td.Respond("getAuthorizationState", tdfake.Object{"@type": string(tgclient.AuthStateWaitCode)})
+	td.Respond("checkAuthenticationCode", tdfake.Error(400, "PHONE_CODE_INVALID"))
+
+	if err := client.Authorize(); err == nil {
+		t.Fatal("invalid code accepted")
+	}
+
+	td.Respond("getAuthorizationState", tdfake.Object{"@type": string(tgclient.AuthStateLoggingOut)})
+	if err := client.Authorize(); !errorx.IsOfType(err, tgclient.AuthErr) {
+		t.Fatalf("authorize while logging out: %+v", err)
+	}
+}
+
+func TestListenNewMessages(t *testing.T) {
+	client, td := newTestClient(t, tgclient.NewBuilder())
+	messages := client.ListenNewMessages()
+
+	td.Push(tdfake.Object{"@type": "updateNewMessage", "message": tdfake.Object{"id": 7, "chat_id": -100}})
+	select {
+	case msg := <-messages:
+		if msg.Id != 7 || msg.ChatId != -100 {
+			t.Fatalf("message: %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	client.Destroy()
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Fatal("unexpected message")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel open after destroy")
+	}
+}
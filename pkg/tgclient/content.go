@@ -0,0 +1,200 @@
+package tgclient
+
+type File struct {
+	Id           int32      `json:"id"`
+	Size         int32      `json:"size"`
+	ExpectedSize int32      `json:"expected_size"`
+	Local        LocalFile  `json:"local"`
+	Remote       RemoteFile `json:"remote"`
+}
+
+type LocalFile struct {
+	Path                   string `json:"path"`
+	IsDownloadingCompleted bool   `json:"is_downloading_completed"`
+}
+
+type RemoteFile struct {
+	Id       string `json:"id"`
+	UniqueId string `json:"unique_id"`
+}
+
+type PhotoSize struct {
+	Type   string `json:"type"`
+	Photo  File   `json:"photo"`
+	Width  int32  `json:"width"`
+	Height int32  `json:"height"`
+}
+
+type Photo struct {
+	Sizes []PhotoSize `json:"sizes"`
+}
+
+// Largest returns the biggest photo size.
+func (p Photo) Largest() (PhotoSize, bool) {
+	var res PhotoSize
+	for _, s := range p.Sizes {
+		if s.Width*s.Height >= res.Width*res.Height {
+			res = s
+		}
+	}
+	return res, len(p.Sizes) > 0
+}
+
+type Video struct {
+	Duration int32  `json:"duration"`
+	Width    int32  `json:"width"`
+	Height   int32  `json:"height"`
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+	Video    File   `json:"video"`
+}
+
+type Document struct {
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+	Document File   `json:"document"`
+}
+
+type Animation struct {
+	Duration  int32  `json:"duration"`
+	Width     int32  `json:"width"`
+	Height    int32  `json:"height"`
+	FileName  string `json:"file_name"`
+	MimeType  string `json:"mime_type"`
+	Animation File   `json:"animation"`
+}
+
+type VoiceNote struct {
+	Duration int32  `json:"duration"`
+	MimeType string `json:"mime_type"`
+	Voice    File   `json:"voice"`
+}
+
+type Audio struct {
+	Duration  int32  `json:"duration"`
+	Title     string `json:"title"`
+	Performer string `json:"performer"`
+	FileName  string `json:"file_name"`
+	MimeType  string `json:"mime_type"`
+	Audio     File   `json:"audio"`
+}
+
+type Sticker struct {
+	Width   int32  `json:"width"`
+	Height  int32  `json:"height"`
+	Emoji   string `json:"emoji"`
+	Sticker File   `json:"sticker"`
+}
+
+type MessagePhoto struct {
+	Photo   Photo         `json:"photo"`
+	Caption FormattedText `json:"caption"`
+}
+
+type MessageVideo struct {
+	Video   Video         `json:"video"`
+	Caption FormattedText `json:"caption"`
+}
+
+type MessageDocument struct {
+	Document Document      `json:"document"`
+	Caption  FormattedText `json:"caption"`
+}
+
+type MessageAnimation struct {
+	Animation Animation     `json:"animation"`
+	Caption   FormattedText `json:"caption"`
+}
+
+type MessageVoiceNote struct {
+	VoiceNote VoiceNote     `json:"voice_note"`
+	Caption   FormattedText `json:"caption"`
+}
+
+type MessageAudio struct {
+	Audio   Audio         `json:"audio"`
+	Caption FormattedText `json:"caption"`
+}
+
+type MessageSticker struct {
+	Sticker Sticker `json:"sticker"`
+}
+
+// Content unmarshals the message content into a typed struct:
+// *MessageText, *MessagePhoto, *MessageVideo, *MessageDocument, *MessageAnimation,
+// *MessageVoiceNote, *MessageAudio or *MessageSticker. Other content types are returned as nil.
+func (m Message) Content() (interface{}, error) {
+	t, err := m.GetContentType()
+	if err != nil {
+		return nil, err
+	}
+	var content interface{}
+	switch t {
+	case MessageTextType:
+		content = &MessageText{}
+	case MessagePhotoType:
+		content = &MessagePhoto{}
+	case MessageVideoType:
+		content = &MessageVideo{}
+	case MessageDocumentType:
+		content = &MessageDocument{}
+	case MessageAnimationType:
+		content = &MessageAnimation{}
+	case MessageVoiceNoteType:
+		content = &MessageVoiceNote{}
+	case MessageAudioType:
+		content = &MessageAudio{}
+	case MessageStickerType:
+		content = &MessageSticker{}
+	default:
+		return nil, nil
+	}
+	err = m.UnmarshalContent(content)
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// ContentText returns the message text or the media caption.
+func ContentText(content interface{}) FormattedText {
+	switch c := content.(type) {
+	case *MessageText:
+		return c.Text
+	case *MessagePhoto:
+		return c.Caption
+	case *MessageVideo:
+		return c.Caption
+	case *MessageDocument:
+		return c.Caption
+	case *MessageAnimation:
+		return c.Caption
+	case *MessageVoiceNote:
+		return c.Caption
+	case *MessageAudio:
+		return c.Caption
+	}
+	return FormattedText{}
+}
+
+// ContentFile returns the file of a media content.
+func ContentFile(content interface{}) (File, bool) {
+	switch c := content.(type) {
+	case *MessagePhoto:
+		size, ok := c.Photo.Largest()
+		return size.Photo, ok
+	case *MessageVideo:
+		return c.Video.Video, true
+	case *MessageDocument:
+		return c.Document.Document, true
+	case *MessageAnimation:
+		return c.Animation.Animation, true
+	case *MessageVoiceNote:
+		return c.VoiceNote.Voice, true
+	case *MessageAudio:
+		return c.Audio.Audio, true
+	case *MessageSticker:
+		return c.Sticker.Sticker, true
+	}
+	return File{}, false
+}
@@ -0,0 +1,189 @@
+package tgclient
+
+import (
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+const DefaultUpdateBufferSize = 1000
+
+// OverflowPolicy defines what happens to an update when a subscriber queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest discards the oldest queued update to make room for the new one.
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDropNewest discards the new update.
+	OverflowDropNewest
+	// OverflowBlock waits until the subscriber takes an update off the queue.
+	// It stalls the whole update loop, including request responses,
+	// so the subscriber must never wait for Send while its queue is full.
+	OverflowBlock
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropOldest:
+		return "drop_oldest"
+	case OverflowDropNewest:
+		return "drop_newest"
+	case OverflowBlock:
+		return "block"
+	}
+	return "unknown"
+}
+
+type SubscribeOptions struct {
+	BufferSize int
+	Overflow   OverflowPolicy
+}
+
+// Subscription is a queue of updates of a single type.
+type Subscription struct {
+	t          ClassType
+	policy     OverflowPolicy
+	dispatcher *dispatcher
+	events     chan Event
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (s *Subscription) Type() ClassType {
+	return s.t
+}
+
+// Events returns the update queue, it is closed after Unsubscribe.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Unsubscribe stops delivery and closes the update queue. It is safe to call it several times.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		s.dispatcher.remove(s)
+		close(s.done)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		close(s.events)
+	})
+}
+
+func (s *Subscription) deliver(ev Event, logger *logrus.Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	switch s.policy {
+	case OverflowBlock:
+		select {
+		case s.events <- ev:
+		case <-s.done:
+		}
+	case OverflowDropNewest:
+		select {
+		case s.events <- ev:
+		default:
+			logger.Warnf("subscriber queue is full, update dropped: %s", ev)
+		}
+	default:
+		for {
+			select {
+			case s.events <- ev:
+				return
+			default:
+			}
+			select {
+			case old := <-s.events:
+				logger.Warnf("subscriber queue is full, update dropped: %s", old)
+			default:
+			}
+		}
+	}
+}
+
+type dispatcher struct {
+	logger   *logrus.Entry
+	defaults SubscribeOptions
+
+	mu   sync.RWMutex
+	subs map[ClassType][]*Subscription
+}
+
+func newDispatcher(logger *logrus.Entry, defaults SubscribeOptions) *dispatcher {
+	if defaults.BufferSize <= 0 {
+		defaults.BufferSize = DefaultUpdateBufferSize
+	}
+	return &dispatcher{
+		logger:   logger,
+		defaults: defaults,
+		subs:     map[ClassType][]*Subscription{},
+	}
+}
+
+func (d *dispatcher) subscribe(t ClassType, opts SubscribeOptions) *Subscription {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = d.defaults.BufferSize
+	}
+	s := &Subscription{
+		t:          t,
+		policy:     opts.Overflow,
+		dispatcher: d,
+		events:     make(chan Event, opts.BufferSize),
+		done:       make(chan struct{}),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subs[t] = append(d.subs[t], s)
+
+	return s
+}
+
+func (d *dispatcher) remove(s *Subscription) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subs := d.subs[s.t]
+	for i, sub := range subs {
+		if sub == s {
+			res := make([]*Subscription, 0, len(subs)-1)
+			res = append(res, subs[:i]...)
+			d.subs[s.t] = append(res, subs[i+1:]...)
+			break
+		}
+	}
+	if len(d.subs[s.t]) == 0 {
+		delete(d.subs, s.t)
+	}
+}
+
+func (d *dispatcher) dispatch(ev Event) {
+	d.mu.RLock()
+	subs := d.subs[ev.Type]
+	d.mu.RUnlock()
+
+	for _, s := range subs {
+		s.deliver(ev, d.logger)
+	}
+}
+
+func (d *dispatcher) closeAll() {
+	d.mu.RLock()
+	var all []*Subscription
+	for _, subs := range d.subs {
+		all = append(all, subs...)
+	}
+	d.mu.RUnlock()
+
+	for _, s := range all {
+		s.Unsubscribe()
+	}
+}
@@ -1,9 +1,129 @@
 package tgclient
 
-import "github.com/joomcode/errorx"
+import (
+	"github.com/joomcode/errorx"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
 
 var Errors = errorx.NewNamespace("tg_errors")
 var ParseErr = Errors.NewType("parse")
-var TimeoutErr = Errors.NewType("timeout")
+var TimeoutErr = Errors.NewType("timeout", errorx.Timeout())
+var CancelErr = Errors.NewType("cancel")
 var AuthErr = Errors.NewType("auth")
 var RequestErr = Errors.NewType("request")
+var CloseErr = Errors.NewType("close")
+
+// Request error classes by TDLib error code, the ErrorEvent is available through AsErrorEvent.
+var (
+	BadRequestErr   = RequestErr.NewSubtype("bad_request")
+	UnauthorizedErr = RequestErr.NewSubtype("unauthorized")
+	NotFoundErr     = RequestErr.NewSubtype("not_found", errorx.NotFound())
+	// IgnoredErr is a 406 error, TDLib has already handled it and it must not be shown to the user.
+	IgnoredErr = RequestErr.NewSubtype("ignored")
+	// FloodWaitErr is a 420 or 429 error, the request may be repeated after FloodWait.
+	FloodWaitErr = RequestErr.NewSubtype("flood_wait", errorx.Temporary())
+)
+
+var errorEventProperty = errorx.RegisterProperty("error_event")
+
+var floodWaitRe = regexp.MustCompile(`(?i)(?:FLOOD_WAIT_|retry after )(\d+)`)
+
+// FloodWait returns the delay parsed from FLOOD_WAIT_X or "retry after X" messages.
+func (e ErrorEvent) FloodWait() time.Duration {
+	m := floodWaitRe.FindStringSubmatch(e.Message)
+	if m == nil {
+		return 0
+	}
+	sec, _ := strconv.Atoi(m[1])
+	return time.Duration(sec) * time.Second
+}
+
+// AsErrorEvent returns the TDLib error if err is, or is caused by, a RequestErr.
+func AsErrorEvent(err error) (ErrorEvent, bool) {
+	for err != nil {
+		e := errorx.Cast(err)
+		if e == nil {
+			return ErrorEvent{}, false
+		}
+		if val, ok := e.Property(errorEventProperty); ok {
+			return val.(ErrorEvent), true
+		}
+		err = e.Cause()
+	}
+	return ErrorEvent{}, false
+}
+
+// IsFloodWait reports whether the request was rejected by flood control.
+func IsFloodWait(err error) bool {
+	return isOfType(err, FloodWaitErr)
+}
+
+// FloodWait returns the delay requested by flood control, zero for other errors.
+func FloodWait(err error) time.Duration {
+	if !IsFloodWait(err) {
+		return 0
+	}
+	errorEv, _ := AsErrorEvent(err)
+	return errorEv.FloodWait()
+}
+
+// IsNotFound reports whether the requested object does not exist.
+func IsNotFound(err error) bool {
+	return isOfType(err, NotFoundErr)
+}
+
+// IsIgnored reports whether the error should be silently ignored.
+func IsIgnored(err error) bool {
+	return isOfType(err, IgnoredErr)
+}
+
+// IsUnauthorized reports whether the client has to log in again.
+func IsUnauthorized(err error) bool {
+	return isOfType(err, UnauthorizedErr)
+}
+
+// isOfType checks the error and its causes, so the class survives wrapping.
+func isOfType(err error, t *errorx.Type) bool {
+	for err != nil {
+		e := errorx.Cast(err)
+		if e == nil {
+			return false
+		}
+		if e.IsOfType(t) {
+			return true
+		}
+		err = e.Cause()
+	}
+	return false
+}
+
+func newRequestError(errorEv ErrorEvent, req Request) *errorx.Error {
+	return classifyError(errorEv).
+		New("req failed. code: %d, msg: %s, req: %s", errorEv.Code, errorEv.Message, req.String()).
+		WithProperty(errorEventProperty, errorEv)
+}
+
+func classifyError(errorEv ErrorEvent) *errorx.Type {
+	switch errorEv.Code {
+	case 400:
+		if strings.Contains(strings.ToLower(errorEv.Message), "not found") {
+			return NotFoundErr
+		}
+		return BadRequestErr
+	case 401:
+		return UnauthorizedErr
+	case 404:
+		return NotFoundErr
+	case 406:
+		return IgnoredErr
+	case 420, 429:
+		return FloodWaitErr
+	}
+	if errorEv.FloodWait() > 0 {
+		return FloodWaitErr
+	}
+	return RequestErr
+}
@@ -0,0 +1,174 @@
+// Package tdfake provides an in-memory scripted TDLib transport,
+// so tgclient can be exercised without libtdjson.
+package tdfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"tg-reposter/pkg/tgclient"
+	"time"
+)
+
+// Object is a decoded TDLib JSON object.
+type Object map[string]interface{}
+
+// Type returns the object "@type" field.
+func (o Object) Type() string {
+	t, _ := o["@type"].(string)
+	return t
+}
+
+// Handler produces a response for a request. Returning nil sends no response.
+type Handler func(req Object) interface{}
+
+// Transport is a scripted tgclient.Transport. Responses are produced by
+// handlers registered per request type, updates are pushed manually.
+type Transport struct {
+	mu       sync.Mutex
+	handlers map[string]Handler
+	sent     []Object
+	queue    chan []byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewTransport() *Transport {
+	return &Transport{
+		handlers: map[string]Handler{},
+		queue:    make(chan []byte, 1024),
+		done:     make(chan struct{}),
+	}
+}
+
+// Factory returns a factory to pass into tgclient.Builder.Transport.
+func (t *Transport) Factory() tgclient.TransportFactory {
+	return func() tgclient.Transport {
+		return t
+	}
+}
+
+// Handle registers a handler for requests of reqType.
+func (t *Transport) Handle(reqType string, h Handler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers[reqType] = h
+}
+
+// Respond registers a static response for requests of reqType.
+func (t *Transport) Respond(reqType string, resp interface{}) {
+	t.Handle(reqType, func(Object) interface{} {
+		return resp
+	})
+}
+
+// Push emits an update, as if it was received from TDLib.
+func (t *Transport) Push(update interface{}) {
+	raw, err := json.Marshal(update)
+	if err != nil {
+		panic(err)
+	}
+	t.queue <- raw
+}
+
+// Sent returns all requests received so far.
+func (t *Transport) Sent() []Object {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make([]Object, len(t.sent))
+	copy(res, t.sent)
+	return res
+}
+
+// SentOfType returns requests of reqType received so far.
+func (t *Transport) SentOfType(reqType string) []Object {
+	var res []Object
+	for _, req := range t.Sent() {
+		if req.Type() == reqType {
+			res = append(res, req)
+		}
+	}
+	return res
+}
+
+func (t *Transport) Send(raw []byte) {
+	req := Object{}
+	err := json.Unmarshal(raw, &req)
+	if err != nil {
+		panic(err)
+	}
+
+	t.mu.Lock()
+	t.sent = append(t.sent, req)
+	h, ok := t.handlers[req.Type()]
+	t.mu.Unlock()
+
+	var resp interface{}
+	if ok {
+		resp = h(req)
+	} else {
+		resp = Error(400, fmt.Sprintf("unexpected request: %s", req.Type()))
+	}
+	if resp == nil {
+		return
+	}
+
+	obj, err := toObject(resp)
+	if err != nil {
+		panic(err)
+	}
+	if extra, ok := req["@extra"]; ok {
+		obj["@extra"] = extra
+	}
+	t.Push(obj)
+}
+
+func (t *Transport) Receive(timeout float64) []byte {
+	timer := time.NewTimer(time.Duration(timeout * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case raw := <-t.queue:
+		return raw
+	case <-t.done:
+		return nil
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (t *Transport) Destroy() {
+	t.once.Do(func() {
+		close(t.done)
+	})
+}
+
+// Error builds a TDLib error object.
+func Error(code int, msg string) Object {
+	return Object{
+		"@type":   "error",
+		"code":    code,
+		"message": msg,
+	}
+}
+
+// Ok builds a TDLib ok object.
+func Ok() Object {
+	return Object{"@type": "ok"}
+}
+
+func toObject(v interface{}) (Object, error) {
+	if obj, ok := v.(Object); ok {
+		res := Object{}
+		for k, val := range obj {
+			res[k] = val
+		}
+		return res, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	obj := Object{}
+	err = json.Unmarshal(raw, &obj)
+	return obj, err
+}
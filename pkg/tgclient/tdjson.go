@@ -0,0 +1,48 @@
+//go:build cgo
+// +build cgo
+
+package tgclient
+
+/*
+#cgo LDFLAGS: -ltdjson
+#include <stdlib.h>
+#include <td/telegram/td_json_client.h>
+#include <td/telegram/td_log.h>
+*/
+import "C"
+import (
+	"unsafe"
+)
+
+func init() {
+	defaultTransport = NewTdJsonTransport
+}
+
+type tdJsonTransport struct {
+	client unsafe.Pointer
+}
+
+// NewTdJsonTransport creates a Transport backed by the libtdjson shared library.
+func NewTdJsonTransport() Transport {
+	return &tdJsonTransport{
+		client: C.td_json_client_create(),
+	}
+}
+
+func (t *tdJsonTransport) Send(req []byte) {
+	cReq := C.CString(string(req))
+	defer C.free(unsafe.Pointer(cReq))
+	C.td_json_client_send(t.client, cReq)
+}
+
+func (t *tdJsonTransport) Receive(timeout float64) []byte {
+	resp := C.td_json_client_receive(t.client, C.double(timeout))
+	if resp == nil {
+		return nil
+	}
+	return []byte(C.GoString(resp))
+}
+
+func (t *tdJsonTransport) Destroy() {
+	C.td_json_client_destroy(t.client)
+}
@@ -0,0 +1,19 @@
+package tgclient
+
+// Transport is a raw TDLib JSON interface: requests and updates are passed
+// as serialized JSON objects, exactly as td_json_client expects them.
+type Transport interface {
+	// Send sends a request to TDLib. Responses are delivered through Receive.
+	Send(req []byte)
+	// Receive waits up to timeout seconds for the next response or update.
+	// Returns nil if nothing was received.
+	Receive(timeout float64) []byte
+	// Destroy releases the transport. It must not be used after that.
+	Destroy()
+}
+
+// TransportFactory creates a new Transport for every built Client.
+type TransportFactory func() Transport
+
+// defaultTransport is set by the libtdjson backend when it is compiled in.
+var defaultTransport TransportFactory
@@ -18,9 +18,45 @@ type typeHolder struct {
 type ClassType string
 
 const (
-	ErrorEventType       ClassType = "error"
-	NewMessageUpdateType ClassType = "updateNewMessage"
-	MessageTextType      ClassType = "messageText"
+	ErrorEventType               ClassType = "error"
+	NewMessageUpdateType         ClassType = "updateNewMessage"
+	MessageEditedUpdateType      ClassType = "updateMessageEdited"
+	MessageContentUpdateType     ClassType = "updateMessageContent"
+	DeleteMessagesUpdateType     ClassType = "updateDeleteMessages"
+	ChatTitleUpdateType          ClassType = "updateChatTitle"
+	AuthorizationStateUpdateType ClassType = "updateAuthorizationState"
+	MessageTextType              ClassType = "messageText"
+	MessagePhotoType             ClassType = "messagePhoto"
+	MessageVideoType             ClassType = "messageVideo"
+	MessageDocumentType          ClassType = "messageDocument"
+	MessageAnimationType         ClassType = "messageAnimation"
+	MessageVoiceNoteType         ClassType = "messageVoiceNote"
+	MessageAudioType             ClassType = "messageAudio"
+	MessageStickerType           ClassType = "messageSticker"
+	MessageVideoNoteType         ClassType = "messageVideoNote"
+	ChatTypePrivateType          ClassType = "chatTypePrivate"
+	ChatTypeBasicGroupType       ClassType = "chatTypeBasicGroup"
+	ChatTypeSupergroupType       ClassType = "chatTypeSupergroup"
+	ChatTypeSecretType           ClassType = "chatTypeSecret"
+)
+
+const (
+	TextEntityMentionType       ClassType = "textEntityTypeMention"
+	TextEntityHashtagType       ClassType = "textEntityTypeHashtag"
+	TextEntityCashtagType       ClassType = "textEntityTypeCashtag"
+	TextEntityBotCommandType    ClassType = "textEntityTypeBotCommand"
+	TextEntityUrlType           ClassType = "textEntityTypeUrl"
+	TextEntityEmailAddressType  ClassType = "textEntityTypeEmailAddress"
+	TextEntityPhoneNumberType   ClassType = "textEntityTypePhoneNumber"
+	TextEntityBoldType          ClassType = "textEntityTypeBold"
+	TextEntityItalicType        ClassType = "textEntityTypeItalic"
+	TextEntityUnderlineType     ClassType = "textEntityTypeUnderline"
+	TextEntityStrikethroughType ClassType = "textEntityTypeStrikethrough"
+	TextEntityCodeType          ClassType = "textEntityTypeCode"
+	TextEntityPreType           ClassType = "textEntityTypePre"
+	TextEntityPreCodeType       ClassType = "textEntityTypePreCode"
+	TextEntityTextUrlType       ClassType = "textEntityTypeTextUrl"
+	TextEntityMentionNameType   ClassType = "textEntityTypeMentionName"
 )
 
 type rawEvent struct {
@@ -56,6 +92,38 @@ type NewMessageUpdate struct {
 	Message Message `json:"message"`
 }
 
+type MessageEditedUpdate struct {
+	ChatId    int64 `json:"chat_id"`
+	MessageId int64 `json:"message_id"`
+	EditDate  int32 `json:"edit_date"`
+}
+
+type MessageContentUpdate struct {
+	ChatId     int64           `json:"chat_id"`
+	MessageId  int64           `json:"message_id"`
+	NewContent json.RawMessage `json:"new_content"`
+}
+
+type DeleteMessagesUpdate struct {
+	ChatId      int64   `json:"chat_id"`
+	MessageIds  []int64 `json:"message_ids"`
+	IsPermanent bool    `json:"is_permanent"`
+	FromCache   bool    `json:"from_cache"`
+}
+
+type ChatTitleUpdate struct {
+	ChatId int64  `json:"chat_id"`
+	Title  string `json:"title"`
+}
+
+type AuthorizationStateUpdate struct {
+	State typeHolder `json:"authorization_state"`
+}
+
+func (u AuthorizationStateUpdate) AuthState() AuthState {
+	return AuthState(u.State.Type)
+}
+
 type AuthState string
 
 const (
@@ -71,8 +139,24 @@ const (
 )
 
 type Chat struct {
-	Id    int64  `json:"id"`
-	Title string `json:"title"`
+	Id    int64    `json:"id"`
+	Type  ChatType `json:"type"`
+	Title string   `json:"title"`
+	Order int64    `json:"order,string"`
+}
+
+type ChatType struct {
+	Type         ClassType `json:"@type"`
+	UserId       int32     `json:"user_id"`
+	BasicGroupId int32     `json:"basic_group_id"`
+	SupergroupId int32     `json:"supergroup_id"`
+	IsChannel    bool      `json:"is_channel"`
+}
+
+type Supergroup struct {
+	Id        int32  `json:"id"`
+	Username  string `json:"username"`
+	IsChannel bool   `json:"is_channel"`
 }
 
 func (c Chat) String() string {
@@ -102,9 +186,27 @@ type Message struct {
 	ChatId       int64           `json:"chat_id"`
 	SenderUserId int32           `json:"sender_user_id"`
 	IsOutgoing   bool            `json:"is_outgoing"`
+	Date         int32           `json:"date"`
+	EditDate     int32           `json:"edit_date"`
+	MediaAlbumId int64           `json:"media_album_id,string"`
+	ForwardInfo  json.RawMessage `json:"forward_info,omitempty"`
 	RawContent   json.RawMessage `json:"content"`
 }
 
+// ServerId returns the message id as seen by Bot API and t.me links.
+func (m Message) ServerId() int64 {
+	return ServerMessageId(m.Id)
+}
+
+// ServerMessageId converts a TDLib message id to the server one.
+func ServerMessageId(id int64) int64 {
+	return id >> 20
+}
+
+func (m Message) IsForwarded() bool {
+	return len(m.ForwardInfo) > 0 && string(m.ForwardInfo) != "null"
+}
+
 func (m Message) UnmarshalContent(obj interface{}) error {
 	err := json.Unmarshal(m.RawContent, obj)
 	if err != nil {
@@ -132,5 +234,20 @@ type MessageText struct {
 }
 
 type FormattedText struct {
-	Text string `json:"text"`
+	Text     string       `json:"text"`
+	Entities []TextEntity `json:"entities"`
+}
+
+// TextEntity is a formatted part of a text. Offset and Length are in UTF-16 code units.
+type TextEntity struct {
+	Offset int32          `json:"offset"`
+	Length int32          `json:"length"`
+	Type   TextEntityType `json:"type"`
+}
+
+type TextEntityType struct {
+	Type     ClassType `json:"@type"`
+	Url      string    `json:"url,omitempty"`
+	UserId   int32     `json:"user_id,omitempty"`
+	Language string    `json:"language,omitempty"`
 }